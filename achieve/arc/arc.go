@@ -0,0 +1,214 @@
+package arc
+
+import (
+	"container/list"
+	"mini-cache/achieve/lru"
+)
+
+/*
+	ARC (Adaptive Replacement Cache) 缓存淘汰策略
+
+	T1: 只被访问过一次的数据(最近访问)     B1: 从T1淘汰的key(幽灵列表, 只保存key)
+	T2: 被访问过至少两次的数据(经常访问)   B2: 从T2淘汰的key(幽灵列表, 只保存key)
+
+	命中B1说明T1太小, 增大T1的目标大小p; 命中B2说明T2太小, 减小p。
+	淘汰时T1超过p则淘汰T1, 否则淘汰T2。p 随访问模式自动调整, 不需要手动调参。
+	这里的容量和p都以字节计算。
+*/
+
+// 所在的列表
+const (
+	t1 = iota
+	t2
+	b1
+	b2
+)
+
+// Cache is an ARC cache. It is not safe for concurrent access.
+// 与 lru.Cache 的接口相同, 可以直接替换。
+type Cache struct {
+	// 允许使用的最大内存, 0 表示不限制
+	maxBytes int64
+	// T1的目标大小
+	p int64
+	// 四个列表, 链表首部为最近访问的数据
+	lists [4]*list.List
+	// 每个列表使用的内存
+	nBytes [4]int64
+	// 字典（key与链表中节点地址的映射）, 包括幽灵列表
+	cache map[string]*list.Element
+	// optional and excuted when an entry is purged.
+	// 可选的，当一个条目被清除时执行
+	OnEvicted func(key string, value Value)
+}
+
+type entry struct {
+	key   string
+	value Value
+	// 幽灵列表中只保留大小
+	size  int64
+	which int
+}
+
+// Value 与 lru.Value 是同一个类型, 为 lru.Cache 编写的 OnEvicted 可以直接使用
+type Value = lru.Value
+
+func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	c := &Cache{
+		maxBytes:  maxBytes,
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+	for i := range c.lists {
+		c.lists[i] = list.New()
+	}
+	return c
+}
+
+// Get looks up a key's value.
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kv := ele.Value.(*entry)
+	if kv.which == b1 || kv.which == b2 {
+		// 幽灵列表中没有数据
+		return nil, false
+	}
+	// 再次被访问, 移动到T2首部
+	c.move(ele, t2)
+	return kv.value, true
+}
+
+// Add adds a value to the cache.
+func (c *Cache) Add(key string, value Value) {
+	size := int64(len(key)) + int64(value.Len())
+	ele, ok := c.cache[key]
+	if !ok {
+		// 新数据放入T1
+		c.cache[key] = c.lists[t1].PushFront(&entry{key: key, value: value, size: size, which: t1})
+		c.nBytes[t1] += size
+		c.evict(false)
+		return
+	}
+
+	kv := ele.Value.(*entry)
+	inB2 := false
+	switch kv.which {
+	case b1:
+		// 命中B1, T1应当更大
+		delta := size
+		if c.nBytes[b1] > 0 && c.nBytes[b2] > c.nBytes[b1] {
+			delta = size * (c.nBytes[b2] / c.nBytes[b1])
+		}
+		c.p += delta
+		if c.maxBytes != 0 && c.p > c.maxBytes {
+			c.p = c.maxBytes
+		}
+	case b2:
+		// 命中B2, T2应当更大
+		delta := size
+		if c.nBytes[b2] > 0 && c.nBytes[b1] > c.nBytes[b2] {
+			delta = size * (c.nBytes[b1] / c.nBytes[b2])
+		}
+		c.p -= delta
+		if c.p < 0 {
+			c.p = 0
+		}
+		inB2 = true
+	}
+	// 更新节点储存的k-v对, 移动到T2首部
+	c.nBytes[kv.which] += size - kv.size
+	kv.value, kv.size = value, size
+	c.move(ele, t2)
+	c.evict(inB2)
+}
+
+// 内存达到设置的最大值，淘汰数据并限制幽灵列表的大小
+func (c *Cache) evict(inB2 bool) {
+	for c.maxBytes != 0 && c.maxBytes < c.nBytes[t1]+c.nBytes[t2] {
+		c.replace(inB2)
+	}
+	if c.maxBytes == 0 {
+		return
+	}
+	// T1+B1 不超过容量, 四个列表总共不超过两倍容量
+	for c.nBytes[b1] > 0 && c.nBytes[t1]+c.nBytes[b1] > c.maxBytes {
+		c.removeGhost(b1)
+	}
+	for c.nBytes[b2] > 0 && c.nBytes[t1]+c.nBytes[t2]+c.nBytes[b1]+c.nBytes[b2] > 2*c.maxBytes {
+		c.removeGhost(b2)
+	}
+}
+
+// RemoveOldest removes the oldest item
+// T1超过目标大小p时淘汰T1中最久没有访问的数据, 否则淘汰T2中最久没有访问的数据。
+func (c *Cache) RemoveOldest() {
+	c.replace(false)
+}
+
+// Remove removes the provided key from the cache.
+// 幽灵列表中的key只删除记录, 不调用 OnEvicted
+func (c *Cache) Remove(key string) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := c.lists[ele.Value.(*entry).which].Remove(ele).(*entry)
+	c.nBytes[kv.which] -= kv.size
+	delete(c.cache, key)
+	if c.OnEvicted != nil && (kv.which == t1 || kv.which == t2) {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+func (c *Cache) replace(inB2 bool) {
+	from, to := t2, b2
+	if c.lists[t1].Len() > 0 && (c.nBytes[t1] > c.p || (inB2 && c.nBytes[t1] == c.p) || c.lists[t2].Len() == 0) {
+		from, to = t1, b1
+	}
+	ele := c.lists[from].Back()
+	if ele == nil {
+		return
+	}
+	kv := ele.Value.(*entry)
+	value := kv.value
+	// 移动到幽灵列表, 不再保存数据
+	c.move(ele, to)
+	kv.value = nil
+	if c.maxBytes == 0 {
+		// 不限制容量时不需要幽灵列表
+		c.removeGhost(to)
+	}
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, value)
+	}
+}
+
+func (c *Cache) removeGhost(which int) {
+	if ele := c.lists[which].Back(); ele != nil {
+		kv := c.lists[which].Remove(ele).(*entry)
+		c.nBytes[which] -= kv.size
+		delete(c.cache, kv.key)
+	}
+}
+
+// 移动到指定列表的首部
+func (c *Cache) move(ele *list.Element, which int) {
+	kv := ele.Value.(*entry)
+	if kv.which == which {
+		c.lists[which].MoveToFront(ele)
+		return
+	}
+	c.lists[kv.which].Remove(ele)
+	c.nBytes[kv.which] -= kv.size
+	kv.which = which
+	c.nBytes[which] += kv.size
+	c.cache[kv.key] = c.lists[which].PushFront(kv)
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return c.lists[t1].Len() + c.lists[t2].Len()
+}
@@ -0,0 +1,124 @@
+package arc
+
+import (
+	"fmt"
+	"mini-cache/achieve/lru"
+	"reflect"
+	"testing"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	if v, ok := arc.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestRemoveOldest(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	cap := len(k1 + k2 + v1 + v2)
+	arc := New(int64(cap), nil)
+	arc.Add(k1, String(v1))
+	arc.Add(k2, String(v2))
+	arc.Add(k3, String(v3))
+
+	if _, ok := arc.Get("key1"); ok || arc.Len() != 2 {
+		t.Fatalf("Removeoldest key1 failed")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value Value) {
+		keys = append(keys, key)
+	}
+	arc := New(int64(10), callback)
+	arc.Add("key1", String("123456"))
+	arc.Add("k2", String("k2"))
+	arc.Add("k3", String("k3"))
+	arc.Add("k4", String("k4"))
+
+	expect := []string{"key1", "k2"}
+
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	keys := make([]string, 0)
+	// 为 lru.Cache 编写的回调函数可以直接使用
+	var callback func(string, lru.Value) = func(key string, value lru.Value) {
+		keys = append(keys, key)
+	}
+	arc := New(int64(8), callback)
+	arc.Add("k1", String("v1"))
+	arc.Add("k2", String("v2"))
+	arc.Add("k3", String("v3"))
+	// k1 已经在幽灵列表中
+	arc.Remove("k1")
+	arc.Remove("k2")
+	arc.Remove("unknown")
+
+	if _, ok := arc.Get("k2"); ok || arc.Len() != 1 {
+		t.Fatalf("Remove k2 failed")
+	}
+	if expect := []string{"k1", "k2"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expect OnEvicted for %v, got %v", expect, keys)
+	}
+	// k1 从幽灵列表中删除, 再次写入时放入T1
+	arc.Add("k1", String("v1"))
+	if arc.nBytes[b1] != 0 || arc.nBytes[t1] != 8 {
+		t.Fatalf("ghost k1 should be removed, nBytes %v", arc.nBytes)
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	// 每个数据占4个字节, 可以存放4个
+	arc := New(int64(16), nil)
+	for _, k := range []string{"h1", "h2"} {
+		arc.Add(k, String("vv"))
+		arc.Get(k)
+	}
+	// 只访问一次的扫描不会挤掉访问过两次的数据
+	for i := 0; i < 100; i++ {
+		arc.Add(fmt.Sprintf("%02d", i), String("vv"))
+	}
+	for _, k := range []string{"h1", "h2"} {
+		if _, ok := arc.Get(k); !ok {
+			t.Fatalf("frequent key %s should survive the scan", k)
+		}
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	arc := New(int64(16), nil)
+	for _, k := range []string{"h1", "h2", "h3", "h4"} {
+		arc.Add(k, String("vv"))
+		arc.Get(k)
+	}
+	arc.Add("r1", String("vv"))
+	arc.Add("r2", String("vv"))
+	// r1 被淘汰到B1后再次加入, T1的目标大小应当增大
+	if arc.p != 0 {
+		t.Fatalf("p should start at 0, got %d", arc.p)
+	}
+	arc.Add("r1", String("vv"))
+	if arc.p == 0 {
+		t.Fatalf("ghost hit in B1 should increase p")
+	}
+	if _, ok := arc.Get("r1"); !ok {
+		t.Fatalf("r1 should be cached after re-adding")
+	}
+}
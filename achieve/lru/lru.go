@@ -84,16 +84,27 @@ func (c *Cache) Add(key string, value Value) {
 func (c *Cache) RemoveOldest() {
 	ele := c.ll.Back()
 	if ele != nil {
-		// 双向链表中移除节点
-		c.ll.Remove(ele)
-		// 字典中移除节点
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		// 更新使用的内存大小
-		c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
-		}
+		c.removeElement(ele)
+	}
+}
+
+// Remove removes the provided key from the cache.
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	// 双向链表中移除节点
+	c.ll.Remove(ele)
+	// 字典中移除节点
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	// 更新使用的内存大小
+	c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
 	}
 }
 
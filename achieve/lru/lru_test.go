@@ -53,3 +53,15 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestRemove(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+	lru.Remove("key1")
+	lru.Remove("unknown")
+
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 {
+		t.Fatalf("Remove key1 failed")
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 准入控制: 限制 HttpServer 同时处理的请求数量
+
+/*
+	请求 --有空闲--> 处理 --完成--> 空闲交给队列中优先级最高的请求
+	  |
+	  |--没有空闲--> 等待队列(按照组的优先级排列) --超过 maxWait--> 503
+	                   |
+	                   |--队列已满--> 挤掉优先级更低的请求(429), 没有时自己返回429
+
+	拒绝的响应都带有 Retry-After。
+*/
+
+const (
+	// 默认的等待队列长度
+	defaultQueueLength = 1000
+	// 被拒绝的请求建议等待的秒数
+	defaultRetryAfter = 1
+)
+
+var (
+	// 等待队列已满, 或者被优先级更高的请求挤掉
+	errQueueFull = errors.New("too many requests")
+	// 在队列中等待超时
+	errQueueTimeout = errors.New("server overloaded")
+)
+
+type waiter struct {
+	priority int
+	// 获得处理的机会时收到nil, 被挤掉时收到 errQueueFull
+	done chan error
+}
+
+type admission struct {
+	// 最多同时处理的请求数量, 等待队列的长度和最长等待时间
+	limit   int
+	queue   int
+	maxWait time.Duration
+
+	mu       sync.Mutex
+	inflight int
+	// 等待的请求, 优先级从高到低, 相同优先级先到先得
+	waiting []*waiter
+}
+
+func newAdmission(limit, queue int, maxWait time.Duration) *admission {
+	return &admission{limit: limit, queue: queue, maxWait: maxWait}
+}
+
+// 获取处理请求的机会, 返回nil时需要调用 release
+func (a *admission) acquire(ctx context.Context, priority int) error {
+	a.mu.Lock()
+	if a.inflight < a.limit {
+		a.inflight++
+		a.mu.Unlock()
+		return nil
+	}
+	if len(a.waiting) >= a.queue {
+		// 挤掉队列中优先级最低并且最晚到达的请求
+		last := len(a.waiting) - 1
+		if last < 0 || a.waiting[last].priority >= priority {
+			a.mu.Unlock()
+			return errQueueFull
+		}
+		a.waiting[last].done <- errQueueFull
+		a.waiting = a.waiting[:last]
+	}
+	w := &waiter{priority: priority, done: make(chan error, 1)}
+	a.enqueue(w)
+	a.mu.Unlock()
+
+	timer := time.NewTimer(a.maxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-w.done:
+		return err
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	a.mu.Lock()
+	removed := a.remove(w)
+	a.mu.Unlock()
+	if removed {
+		return err
+	}
+	// 超时的同时已经获得了处理的机会或者被挤掉, 以收到的结果为准
+	return <-w.done
+}
+
+// 处理完成, 空闲交给队列中的第一个请求
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.waiting) == 0 {
+		a.inflight--
+		return
+	}
+	w := a.waiting[0]
+	a.waiting = a.waiting[1:]
+	w.done <- nil
+}
+
+// 插入到相同优先级的请求之后
+func (a *admission) enqueue(w *waiter) {
+	i := len(a.waiting)
+	for i > 0 && a.waiting[i-1].priority < w.priority {
+		i--
+	}
+	a.waiting = append(a.waiting, nil)
+	copy(a.waiting[i+1:], a.waiting[i:])
+	a.waiting[i] = w
+}
+
+func (a *admission) remove(w *waiter) bool {
+	for i, v := range a.waiting {
+		if v == w {
+			a.waiting = append(a.waiting[:i], a.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 返回拒绝的响应: 队列已满为429, 等待超时为503
+func rejectRequest(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(defaultRetryAfter))
+	if errors.Is(err, errQueueFull) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+// WithConcurrencyLimit 设置最多同时处理的请求数量 limit, 超过时最多 queue 个请求排队等待,
+// 每个请求最多等待 maxWait。队列已满时返回429, 等待超时返回503, 都带有 Retry-After。
+// limit 和 maxWait 为0时不修改, queue 为0时不排队。默认为 5000, 1000, 5s。
+func WithConcurrencyLimit(limit, queue int, maxWait time.Duration) HttpServerOption {
+	return func(p *HttpServer) {
+		if limit > 0 {
+			p.admission.limit = limit
+		}
+		if queue >= 0 {
+			p.admission.queue = queue
+		}
+		if maxWait > 0 {
+			p.admission.maxWait = maxWait
+		}
+	}
+}
+
+// WithGroupPriority 设置组的优先级, 默认为0。并发数量达到上限时, 优先级高的组的请求先处理,
+// 队列已满时挤掉优先级更低的请求。
+func WithGroupPriority(group string, priority int) HttpServerOption {
+	return func(p *HttpServer) {
+		p.priorities[group] = priority
+	}
+}
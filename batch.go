@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	pb "mini-cache/proto"
+	"mini-cache/view"
+	"sync"
+)
+
+// 批量读取
+
+/*
+	keys --> 查找本地缓存和热点缓存 --> 全部命中, 返回
+	            |  未命中的key
+	            |-----> 按照负责的节点分组
+	                      |-----> 远程节点: 每个节点一次批量请求 --> 节点没有响应时回退到本地载入
+	                      |-----> 本节点: 并发从数据源载入
+*/
+
+// GetMany 批量读取多个key, 返回的值和错误与keys一一对应。
+// 未命中缓存的key按照负责的节点分组, 每个远程节点只发送一次批量请求, 本节点负责的key一起载入,
+// 总耗时取决于最慢的一组而不是所有key的耗时之和。
+func (g *Group) GetMany(ctx context.Context, keys []string) ([]view.ByteView, []error) {
+	return g.getMany(ctx, keys, true)
+}
+
+// forward 为false时所有的key都在本地载入, 用于处理其他节点的批量请求
+func (g *Group) getMany(ctx context.Context, keys []string, forward bool) ([]view.ByteView, []error) {
+	values := make([]view.ByteView, len(keys))
+	errs := make([]error, len(keys))
+
+	// 未命中缓存的key在keys中的位置, 重复的key只载入一次
+	missing := make(map[string][]int)
+	var misses []string
+	for i, key := range keys {
+		if key == "" {
+			errs[i] = errors.New("key is required")
+			continue
+		}
+		g.Stats.Gets.Add(1)
+		if v, ok := g.lookupCache(key); ok {
+			values[i] = v
+			continue
+		}
+		if g.lookupNegative(key) {
+			errs[i] = notFound(key)
+			continue
+		}
+		if _, ok := missing[key]; !ok {
+			misses = append(misses, key)
+		}
+		missing[key] = append(missing[key], i)
+	}
+	if len(misses) == 0 {
+		return values, errs
+	}
+	g.Stats.Loads.Add(int64(len(misses)))
+
+	// 按照负责的节点分组
+	var local []string
+	byPeer := make(map[PeerServer][]string)
+	for _, key := range misses {
+		if !forward {
+			local = append(local, key)
+		} else if peer, ok := g.pickPeer(key); ok {
+			byPeer[peer] = append(byPeer[peer], key)
+		} else {
+			local = append(local, key)
+		}
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	done := func(key string, v view.ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, i := range missing[key] {
+			values[i], errs[i] = v, err
+		}
+	}
+	loadLocal := func(keys []string) {
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				var (
+					v   view.ByteView
+					err error
+				)
+				if forward {
+					v, err = g.loadLocally(ctx, key)
+				} else {
+					v, err = g.loadForPeer(ctx, key)
+				}
+				done(key, v, err)
+			}(key)
+		}
+	}
+
+	loadLocal(local)
+	for peer, peerKeys := range byPeer {
+		wg.Add(1)
+		go func(peer PeerServer, peerKeys []string) {
+			defer wg.Done()
+			// 远程节点没有响应时回退到本地载入, 与 Get 相同
+			loadLocal(g.getManyFromCluster(ctx, peer, peerKeys, done))
+		}(peer, peerKeys)
+	}
+	wg.Wait()
+	return values, errs
+}
+
+// 从远程节点批量获取, 返回需要回退到本地载入的key
+func (g *Group) getManyFromCluster(ctx context.Context, peer PeerServer, keys []string,
+	done func(key string, v view.ByteView, err error)) []string {
+	g.Stats.LoadsDeduped.Add(int64(len(keys)))
+	req := &pb.BatchRequest{
+		Group: g.name,
+		Keys:  keys,
+	}
+	res := &pb.BatchResponse{}
+	peerCtx, cancel := context.WithTimeout(ctx, g.peerTimeout)
+	err := peer.GetMany(peerCtx, req, res)
+	cancel()
+	if err != nil {
+		g.Stats.PeerErrors.Add(int64(len(keys)))
+		// 调用方已经取消, 不再载入
+		if ctx.Err() != nil {
+			for _, key := range keys {
+				done(key, view.ByteView{}, ctx.Err())
+			}
+			return nil
+		}
+		log.Println("[GeeCache] Failed to get from peer", err)
+		return keys
+	}
+
+	pending := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		pending[key] = true
+	}
+	for _, e := range res.GetEntries() {
+		// 忽略没有请求的key
+		if !pending[e.GetKey()] {
+			continue
+		}
+		delete(pending, e.GetKey())
+		// 负责的节点确认不存在, 不需要回退到本地
+		if e.GetNotFound() {
+			done(e.GetKey(), view.ByteView{}, notFound(e.GetKey()))
+			continue
+		}
+		// 负责的节点载入失败, 回退到本地只会重复访问数据源
+		if e.GetError() != "" {
+			g.Stats.PeerErrors.Add(1)
+			done(e.GetKey(), view.ByteView{}, ownerLoadError(e.GetError()))
+			continue
+		}
+		v := view.ByteView{B: e.GetValue(), Stale: e.GetStale()}
+		g.Stats.PeerLoads.Add(1)
+		g.populateHotCache(e.GetKey(), v)
+		done(e.GetKey(), v, nil)
+	}
+
+	// 远程节点没有返回的key
+	var failed []string
+	for _, key := range keys {
+		if pending[key] {
+			failed = append(failed, key)
+		}
+	}
+	g.Stats.PeerErrors.Add(int64(len(failed)))
+	return failed
+}
+
+// 从数据源载入, 不会访问远程节点
+func (g *Group) loadLocally(ctx context.Context, key string) (view.ByteView, error) {
+	return g.loadShared(ctx, key, func(ctx context.Context) (interface{}, error) {
+		g.Stats.LoadsDeduped.Add(1)
+		return g.getFromLocalDB(ctx, key)
+	})
+}
+
+// 处理其他节点的批量请求, 只在本地查找和载入
+func (g *Group) batchResponse(ctx context.Context, keys []string) *pb.BatchResponse {
+	values, errs := g.getMany(ctx, keys, false)
+	res := &pb.BatchResponse{Entries: make([]*pb.Entry, len(keys))}
+	for i, key := range keys {
+		e := &pb.Entry{Key: key}
+		if errors.Is(errs[i], ErrNotFound) {
+			e.NotFound = true
+		} else if errs[i] != nil {
+			e.Error = errs[i].Error()
+		} else {
+			e.Value = values[i].ByteSlice()
+			e.Stale = values[i].Stale
+		}
+		res.Entries[i] = e
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchGettr 是可选接口, 回调函数一次载入多个key, 例如一条 SELECT ... WHERE key IN (...)。
+// 传入 NewGroup 或 NewGroupContext 的回调函数如果同时实现了 BatchGettr,
+// 同一时间窗口内并发未命中的key会被合并, 只调用一次 GetMany。
+// 返回的map中没有的key视为不存在(ErrNotFound); 返回error时这一批key都失败。
+type BatchGettr interface {
+	GetMany(keys []string) (map[string][]byte, error)
+}
+
+const (
+	// 默认等待1ms收集并发的未命中
+	defaultBatchWindow = time.Millisecond
+	// 默认每批最多100个key
+	defaultBatchMaxSize = 100
+)
+
+// 收集一段时间内的key, 合并为一次 BatchGettr.GetMany 调用
+type batchLoader struct {
+	gettr BatchGettr
+	// 第一个key到达之后等待的时间
+	window time.Duration
+	// 达到这个数量时立即载入, 不再等待
+	maxSize int
+	// 载入一批之后调用, 用于统计
+	onBatch func(size int)
+
+	mu sync.Mutex
+	// 正在收集的一批, 为空表示没有
+	cur *batch
+}
+
+// 一批等待载入的key
+type batch struct {
+	keys  []string
+	calls map[string]*batchCall
+	timer *time.Timer
+}
+
+// 一个key的载入结果
+type batchCall struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+func newBatchLoader(gettr BatchGettr, window time.Duration, maxSize int, onBatch func(size int)) *batchLoader {
+	return &batchLoader{
+		gettr:   gettr,
+		window:  window,
+		maxSize: maxSize,
+		onBatch: onBatch,
+	}
+}
+
+// load 把key加入当前这一批, 等待这一批载入结束或者 ctx 被取消
+func (l *batchLoader) load(ctx context.Context, key string) ([]byte, error) {
+	l.mu.Lock()
+	b := l.cur
+	if b == nil {
+		b = &batch{calls: make(map[string]*batchCall)}
+		l.cur = b
+		b.timer = time.AfterFunc(l.window, func() { l.flush(b) })
+	}
+	c, ok := b.calls[key]
+	if !ok {
+		c = &batchCall{done: make(chan struct{})}
+		b.calls[key] = c
+		b.keys = append(b.keys, key)
+	}
+	full := len(b.keys) >= l.maxSize
+	if full {
+		// 在锁内取下这一批, 之后的key放入新的一批
+		l.cur = nil
+	}
+	l.mu.Unlock()
+
+	if full {
+		b.timer.Stop()
+		go l.run(b)
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 时间窗口结束, 载入这一批key
+func (l *batchLoader) flush(b *batch) {
+	l.mu.Lock()
+	if l.cur != b {
+		// 已经达到最大数量被载入
+		l.mu.Unlock()
+		return
+	}
+	l.cur = nil
+	l.mu.Unlock()
+	l.run(b)
+}
+
+// 载入一批已经取下的key
+func (l *batchLoader) run(b *batch) {
+	if l.onBatch != nil {
+		l.onBatch(len(b.keys))
+	}
+	values, err := l.gettr.GetMany(b.keys)
+	for _, key := range b.keys {
+		c := b.calls[key]
+		if err != nil {
+			c.err = err
+		} else if v, ok := values[key]; ok {
+			c.val = v
+		} else {
+			c.err = notFound(key)
+		}
+		close(c.done)
+	}
+}
@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mini-cache/metrics"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 每个远程节点一个熔断器
+
+/*
+	closed --窗口内错误率超过阈值--> open --openTimeout之后--> half-open
+	   ↑                                ↑                        |
+	   |                                └-------探测请求失败-------┤
+	   └----------------------------------------探测请求成功------┘
+
+	超过 slowThreshold 的请求也算作失败。open 状态下 PickPeer 和 PickReplicas 不返回这个节点,
+	Group 直接回退到本地或者下一个副本, 不需要等待请求失败。
+	half-open 的探测机会只在真正发送请求时占用, 选择节点不会占用。
+	熔断只用于读取: Set 和 Remove 必须到达负责的节点, 总是发送, 失败时返回错误。
+*/
+
+const (
+	defaultBreakerPath = "/admin/breakers"
+
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerErrorRate   = 0.5
+	defaultBreakerSlow        = 2 * time.Second
+	defaultBreakerOpenTimeout = 5 * time.Second
+)
+
+// 熔断器没有放行请求
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type breakerConfig struct {
+	// 统计错误率的时间窗口, 以及窗口内至少需要的请求数量
+	window      time.Duration
+	minRequests int
+	// 错误率超过这个值时熔断, 为0时不使用熔断器
+	errorRate float64
+	// 超过这个时间的请求算作失败, 0 表示不考虑延迟
+	slowThreshold time.Duration
+	// 熔断之后等待多久进入 half-open
+	openTimeout time.Duration
+}
+
+func defaultBreakerConfig() breakerConfig {
+	return breakerConfig{
+		window:        defaultBreakerWindow,
+		minRequests:   defaultBreakerMinRequests,
+		errorRate:     defaultBreakerErrorRate,
+		slowThreshold: defaultBreakerSlow,
+		openTimeout:   defaultBreakerOpenTimeout,
+	}
+}
+
+type breaker struct {
+	config breakerConfig
+
+	mu    sync.Mutex
+	state breakerState
+	// 当前窗口的开始时间, 窗口内的请求和失败数量
+	windowStart time.Time
+	requests    int
+	failures    int
+	// 进入 open 的时间
+	openedAt time.Time
+	// half-open 状态下最近一次放行探测请求的时间
+	probeAt time.Time
+	// 熔断的次数
+	opens int64
+}
+
+func newBreaker(config breakerConfig) *breaker {
+	return &breaker{config: config, windowStart: time.Now()}
+}
+
+// 是否可以向节点发送请求。half-open 状态下每个 openTimeout 只放行一个探测请求,
+// 探测请求没有结果时(例如选择节点之后没有发送), 下一个 openTimeout 再放行一个
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.config.openTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probeAt = now
+		return true
+	case breakerHalfOpen:
+		if now.Sub(b.probeAt) < b.config.openTimeout {
+			return false
+		}
+		b.probeAt = now
+		return true
+	}
+	return true
+}
+
+// 与 allow 相同, 但是不占用 half-open 的探测机会, 用于选择节点
+func (b *breaker) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		return now.Sub(b.openedAt) >= b.config.openTimeout
+	case breakerHalfOpen:
+		return now.Sub(b.probeAt) >= b.config.openTimeout
+	}
+	return true
+}
+
+// 记录一次请求的结果
+func (b *breaker) record(failed bool, elapsed time.Duration) {
+	if b.config.slowThreshold > 0 && elapsed > b.config.slowThreshold {
+		failed = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.open(now)
+		} else {
+			b.state = breakerClosed
+			b.reset(now)
+		}
+		return
+	case breakerOpen:
+		// 熔断之前发出的请求, 忽略
+		return
+	}
+
+	if now.Sub(b.windowStart) > b.config.window {
+		b.reset(now)
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.config.minRequests && float64(b.failures) >= b.config.errorRate*float64(b.requests) {
+		b.open(now)
+	}
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+	b.opens++
+	b.reset(now)
+}
+
+func (b *breaker) reset(now time.Time) {
+	b.windowStart = now
+	b.requests, b.failures = 0, 0
+}
+
+// 熔断器的状态, 用于管理接口
+type BreakerStatus struct {
+	State    string `json:"state"`
+	Requests int    `json:"requests"`
+	Failures int    `json:"failures"`
+	Opens    int64  `json:"opens"`
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	// open 已经超时但是还没有被访问过, 下一次请求会进入 half-open
+	if state == breakerOpen && time.Since(b.openedAt) >= b.config.openTimeout {
+		state = breakerHalfOpen
+	}
+	return BreakerStatus{
+		State:    state.String(),
+		Requests: b.requests,
+		Failures: b.failures,
+		Opens:    b.opens,
+	}
+}
+
+// 节点是否可以被选择, 没有熔断器时总是可以
+func (h *httpClient) available() bool {
+	return h.breaker == nil || h.breaker.ready()
+}
+
+// 请求失败是否说明节点不健康: 节点正常返回的不存在和数据源错误不算, 调用方主动取消的请求不统计
+func peerFailed(ctx context.Context, err error) (failed, ok bool) {
+	switch {
+	case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, errOwnerLoad):
+		return false, true
+	case errors.Is(err, errPeerClosed), errors.Is(err, errBreakerOpen), errors.Is(ctx.Err(), context.Canceled):
+		return false, false
+	}
+	return true, true
+}
+
+// Breakers 返回每个远程节点的熔断器状态
+func (p *HttpServer) Breakers() map[string]BreakerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make(map[string]BreakerStatus, len(p.httpClient))
+	for peer, c := range p.httpClient {
+		if c.breaker != nil && peer != p.selfPath {
+			status[peer] = c.breaker.status()
+		}
+	}
+	return status
+}
+
+// 管理接口, 以JSON格式返回每个远程节点的熔断器状态
+func (p *HttpServer) serveBreakers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Breakers())
+}
+
+// WithCircuitBreaker 设置每个远程节点的熔断器: 窗口内至少 minRequests 个请求并且错误率达到 errorRate 时熔断,
+// 超过 slowThreshold 的请求算作失败, 熔断 openTimeout 之后放行一个探测请求, 成功则恢复。
+// errorRate <= 0 时不使用熔断器。默认为 20 个请求, 0.5, 2s, 5s, 统计窗口为10s。
+func WithCircuitBreaker(minRequests int, errorRate float64, slowThreshold, openTimeout time.Duration) HttpServerOption {
+	return func(p *HttpServer) {
+		p.breaker.errorRate = errorRate
+		if minRequests > 0 {
+			p.breaker.minRequests = minRequests
+		}
+		if slowThreshold >= 0 {
+			p.breaker.slowThreshold = slowThreshold
+		}
+		if openTimeout > 0 {
+			p.breaker.openTimeout = openTimeout
+		}
+	}
+}
+
+// 以Prometheus文本格式输出每个远程节点的熔断器状态, 当前状态为1, 其他状态为0
+func (p *HttpServer) writeBreakerMetrics(w io.Writer) {
+	status := p.Breakers()
+	peers := make([]string, 0, len(status))
+	for peer := range status {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+
+	states := []breakerState{breakerClosed, breakerOpen, breakerHalfOpen}
+	metrics.WriteHeader(w, "minicache_peer_breaker_state", "State of the circuit breaker of the peer, 1 for the current state.", "gauge")
+	for _, peer := range peers {
+		for _, state := range states {
+			var v float64
+			if status[peer].State == state.String() {
+				v = 1
+			}
+			metrics.WriteSample(w, "minicache_peer_breaker_state", metrics.Labels("peer", peer, "state", state.String()), v)
+		}
+	}
+	metrics.WriteHeader(w, "minicache_peer_breaker_opens_total", "Times the circuit breaker of the peer opened.", "counter")
+	for _, peer := range peers {
+		metrics.WriteSample(w, "minicache_peer_breaker_opens_total", metrics.Labels("peer", peer), float64(status[peer].Opens))
+	}
+}
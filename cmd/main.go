@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 
 	"log"
+	"net"
 	"net/http"
+	"strings"
 
 	cache "mini-cache"
+	"mini-cache/membership"
 )
 
 // var db = map[string]string{
@@ -45,23 +49,66 @@ func createGroup() *cache.Group {
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
+			return nil, fmt.Errorf("%s not exist: %w", key, cache.ErrNotFound)
 		}))
 }
 
-func startCacheServer(addr string, addrs []string, group *cache.Group) {
+// 通过gossip发现节点, meta 为本节点缓存服务的地址
+func startMembership(gossipAddr string, seeds []string, meta string, peers membership.PeerSetter) {
+	transport, err := membership.NewUDPTransport(gossipAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	node := membership.New(transport, membership.Config{
+		Addr:     gossipAddr,
+		Meta:     meta,
+		OnChange: membership.UpdatePeers(peers),
+	})
+	if err := node.Join(seeds...); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("membership is running at", gossipAddr)
+}
+
+// gossipAddr 为空时使用静态的 addrs
+func startCacheServer(addr string, addrs []string, group *cache.Group, gossipAddr string, seeds []string) {
 	peers := cache.NewHttpServer(addr)
-	peers.Set(addrs...)
+	if gossipAddr == "" {
+		peers.Set(addrs...)
+	} else {
+		startMembership(gossipAddr, seeds, addr, peers)
+	}
 	group.RegisterPeers(peers)
 	log.Println("cache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	log.Fatal(http.ListenAndServe(strings.TrimPrefix(addr, "http://"), peers))
+}
+
+// addr 和 addrs 不包括 "http://"
+func startGrpcCacheServer(addr string, addrs []string, group *cache.Group, gossipAddr string, seeds []string) {
+	peers := cache.NewGrpcServer(addr)
+	if gossipAddr == "" {
+		peers.Set(addrs...)
+	} else {
+		startMembership(gossipAddr, seeds, addr, peers)
+	}
+	group.RegisterPeers(peers)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("cache is running at", addr, "(grpc)")
+	log.Fatal(peers.Serve(lis))
 }
 
 func startAPIServer(apiAddr string, group *cache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
-			view, err := group.Get(key)
+			view, err := group.GetContext(r.Context(), key)
+			if errors.Is(err, cache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -70,14 +117,43 @@ func startAPIServer(apiAddr string, group *cache.Group) {
 			w.Write(view.ByteSlice())
 		}))
 	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	log.Fatal(http.ListenAndServe(strings.TrimPrefix(apiAddr, "http://"), nil))
+}
+
+// 返回本节点缓存服务的地址, 以 "http://" 开头。
+// 静态节点列表只包含 addrMap 中的节点; gossip 模式下为 addr, 没有设置时使用 localhost 和 port
+func selfAddr(addr string, port int, addrMap map[int]string, gossip bool) (string, error) {
+	if !gossip {
+		self, ok := addrMap[port]
+		if !ok {
+			return "", fmt.Errorf("port %d is not in the static peer list, use -gossip to join other nodes", port)
+		}
+		return self, nil
+	}
+	if addr == "" {
+		addr = fmt.Sprintf("http://localhost:%d", port)
+	}
+	if !strings.HasPrefix(addr, "http://") {
+		return "", fmt.Errorf("invalid address %q, expect http://host:port", addr)
+	}
+	if _, _, err := net.SplitHostPort(strings.TrimPrefix(addr, "http://")); err != nil {
+		return "", fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	return addr, nil
 }
 
 func main() {
 	var port int
+	var addr string
 	var api bool
+	var transport string
+	var gossipAddr, seeds string
 	flag.IntVar(&port, "port", 8001, "cache server port")
+	flag.StringVar(&addr, "addr", "", "cache server address in gossip mode, e.g. http://10.0.0.2:8004; defaults to localhost and -port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&transport, "transport", "http", "peer transport: http or grpc")
+	flag.StringVar(&gossipAddr, "gossip", "", "gossip address, e.g. localhost:7001; empty uses the static peer list")
+	flag.StringVar(&seeds, "seeds", "", "comma separated gossip addresses of seed nodes")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
@@ -87,6 +163,16 @@ func main() {
 		8003: "http://localhost:8003",
 	}
 
+	self, err := selfAddr(addr, port, addrMap, gossipAddr != "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var seedList []string
+	if seeds != "" {
+		seedList = strings.Split(seeds, ",")
+	}
+
 	var addrs []string
 	for _, v := range addrMap {
 		addrs = append(addrs, v)
@@ -96,5 +182,15 @@ func main() {
 	if api {
 		go startAPIServer(apiAddr, gee)
 	}
-	startCacheServer(addrMap[port], []string(addrs), gee)
+	switch transport {
+	case "http":
+		startCacheServer(self, []string(addrs), gee, gossipAddr, seedList)
+	case "grpc":
+		for i := range addrs {
+			addrs[i] = strings.TrimPrefix(addrs[i], "http://")
+		}
+		startGrpcCacheServer(strings.TrimPrefix(self, "http://"), addrs, gee, gossipAddr, seedList)
+	default:
+		log.Fatalf("unknown transport %q", transport)
+	}
 }
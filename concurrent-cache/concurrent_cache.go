@@ -1,58 +1,246 @@
 package concurrentcache
 
-import "mini-cache/view"
+import (
+	"mini-cache/view"
+	"sync"
+	"time"
+)
 
+// 后台清理过期数据的默认周期
+const defaultSweepInterval = time.Minute
+
+// ConcurrentCache 并发安全的缓存
+// 数据存放在分片map中, 淘汰顺序交给 Policy 决定。
 type ConcurrentCache struct {
 	cacheMaxBytes uint64
-	cl            *concurrentList
 	cm            concurrentMap
+
+	// 保护淘汰策略和内存统计
+	mu        sync.Mutex
+	policy    Policy
+	length    uint64 // 元素个数
+	usedBytes uint64 // 使用的内存数量
+
+	// 数据因为容量不足被淘汰或者过期时调用, 主动删除和替换时不调用。
+	// 在锁外调用, 需要在使用缓存之前设置
+	OnEvicted func(key string, value view.ByteView)
+
+	// 后台清理过期数据, 只会启动一次
+	sweepOnce sync.Once
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// 创建使用LRU淘汰策略的缓存
+func NewConcurrentCache(maxBytes uint64) *ConcurrentCache {
+	return NewConcurrentCacheWithPolicy(maxBytes, nil)
 }
 
-func NewConcurrentCache(maxBytes uint64) ConcurrentCache {
-	return ConcurrentCache{
+// 创建使用指定淘汰策略的缓存, newPolicy 为空时使用LRU
+func NewConcurrentCacheWithPolicy(maxBytes uint64, newPolicy NewPolicyFunc) *ConcurrentCache {
+	if newPolicy == nil {
+		newPolicy = NewLRU
+	}
+	return &ConcurrentCache{
 		cacheMaxBytes: maxBytes,
-		cl:            newConcurrentList(),
 		cm:            newConcurrentMap(),
+		policy:        newPolicy(maxBytes),
+		stop:          make(chan struct{}),
 	}
 }
 
+// Add 添加一个永不过期的缓存值
 func (c *ConcurrentCache) Add(key string, v view.ByteView) {
-	if n, ok := c.cm.get(key); ok { // 已经存在
-		c.cl.delete(n)  // 从队列中删除
-		n.data = v      // 更新
-		c.cl.enqueue(n) // 入队列
+	c.AddWithTTL(key, v, 0)
+}
+
+// AddWithTTL 添加一个缓存值, ttl 之后过期, ttl <= 0 表示永不过期
+func (c *ConcurrentCache) AddWithTTL(key string, v view.ByteView, ttl time.Duration) {
+	c.AddWithRefresh(key, v, 0, ttl)
+}
+
+// AddWithRefresh 添加一个缓存值, refresh 之后需要刷新(仍然可以读取), ttl 之后过期。
+// refresh <= 0 表示不需要刷新, ttl <= 0 表示永不过期。
+func (c *ConcurrentCache) AddWithRefresh(key string, v view.ByteView, refresh, ttl time.Duration) {
+	now := time.Now()
+	e := &entry{key: key, data: v, added: now}
+	if refresh > 0 {
+		e.refresh = now.Add(refresh)
+	}
+	if ttl > 0 {
+		e.expire = now.Add(ttl)
+		// 第一次出现会过期的数据时启动后台清理
+		c.StartSweeper(defaultSweepInterval)
+	}
+
+	c.mu.Lock()
+	if old, ok := c.cm.get(key); ok { // 已经存在, 替换
+		c.usedBytes -= old.data.Len()
 	} else {
-		n := &node{prev: nil, next: nil, entry: entry{key: key, data: v}}
-		c.cm.set(key, n)
-		c.cl.enqueue(n)
+		c.length++
 	}
+	c.cm.set(key, e)
+	c.usedBytes += v.Len()
+	c.policy.Add(key, v.Len())
+	evicted := c.removeOldest()
+	c.mu.Unlock()
+	c.evicted(evicted...)
 }
 
+// Get 获取缓存值, 已经过期的数据视为未命中并被删除
 func (c *ConcurrentCache) Get(key string) (v view.ByteView, ok bool) {
-	n, ok := c.cm.get(key)
-	c.cl.delete(n)
-	c.cl.enqueue(n)
-	go c.RemoveOldest()
-	return n.data, ok
+	item, ok := c.GetItem(key)
+	return item.Value, ok
+}
+
+// Item 缓存值和它的时间信息, 零值的时间表示没有设置
+type Item struct {
+	Value view.ByteView
+	// 写入的时间
+	Added time.Time
+	// 需要刷新的时间
+	Refresh time.Time
+	// 过期的时间
+	Expire time.Time
+}
+
+// Stale 返回 now 时是否需要刷新
+func (i Item) Stale(now time.Time) bool {
+	return !i.Refresh.IsZero() && now.After(i.Refresh)
+}
+
+// GetItem 与 Get 相同, 同时返回时间信息
+func (c *ConcurrentCache) GetItem(key string) (Item, bool) {
+	e, ok := c.cm.get(key)
+	if !ok {
+		return Item{}, false
+	}
+	if e.expired(time.Now()) {
+		if c.removeEntry(e) {
+			c.evicted(e)
+		}
+		return Item{}, false
+	}
+	c.mu.Lock()
+	c.policy.Access(key)
+	c.mu.Unlock()
+	return Item{Value: e.data, Added: e.added, Refresh: e.refresh, Expire: e.expire}, true
 }
 
+// Remove 删除一个key, key不存在时什么也不做
+func (c *ConcurrentCache) Remove(key string) {
+	if e, ok := c.cm.get(key); ok {
+		c.removeEntry(e)
+	}
+}
+
+// 删除e, 返回是否删除成功
+func (c *ConcurrentCache) removeEntry(e *entry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 加锁之后再次确认, 可能已经被删除或者替换
+	if cur, ok := c.cm.get(e.key); !ok || cur != e {
+		return false
+	}
+	c.cm.deleteEntry(e.key, e)
+	c.policy.Remove(e.key)
+	c.length--
+	c.usedBytes -= e.data.Len()
+	return true
+}
+
+// RemoveOldest 按照淘汰策略删除数据, 直到使用的内存不超过容量
 func (c *ConcurrentCache) RemoveOldest() {
-	for c.cacheMaxBytes != 0 && c.cacheMaxBytes < c.cl.usedMemorySize() {
-		n := c.cl.dequeue()
-		c.cm.delete(n.key)
+	c.mu.Lock()
+	evicted := c.removeOldest()
+	c.mu.Unlock()
+	c.evicted(evicted...)
+}
+
+// 返回被淘汰的数据, 调用方在锁外通知 OnEvicted
+func (c *ConcurrentCache) removeOldest() []*entry {
+	var evicted []*entry
+	for c.cacheMaxBytes != 0 && c.cacheMaxBytes < c.usedBytes {
+		key, ok := c.policy.Evict()
+		if !ok {
+			break
+		}
+		if e, ok := c.cm.get(key); ok {
+			c.cm.deleteEntry(key, e)
+			c.length--
+			c.usedBytes -= e.data.Len()
+			evicted = append(evicted, e)
+		}
+	}
+	return evicted
+}
+
+func (c *ConcurrentCache) evicted(entries ...*entry) {
+	if c.OnEvicted == nil {
+		return
 	}
+	for _, e := range entries {
+		c.OnEvicted(e.key, e.data)
+	}
+}
+
+// RemoveExpired 删除所有已经过期的数据
+func (c *ConcurrentCache) RemoveExpired() {
+	now := time.Now()
+	for _, e := range c.cm.filter(func(e *entry) bool { return e.expired(now) }) {
+		if c.removeEntry(e) {
+			c.evicted(e)
+		}
+	}
+}
+
+// StartSweeper 启动后台清理, 每隔 interval 删除一次过期数据。
+// 多次调用只有第一次生效。
+func (c *ConcurrentCache) StartSweeper(interval time.Duration) {
+	c.sweepOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					c.RemoveExpired()
+				case <-c.stop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// Close 停止后台清理
+func (c *ConcurrentCache) Close() {
+	c.closeOnce.Do(func() { close(c.stop) })
 }
 
 func (c *ConcurrentCache) KeyCount() uint64 {
-	return c.cl.keyCount()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.length
 }
 
 func (c *ConcurrentCache) UsedMemorySize() uint64 {
-	return c.cl.usedMemorySize()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usedBytes
 }
 
-// 存放在node中的数据格式
+// 存放在map中的数据格式, 写入之后不再修改
 type entry struct {
-	key  string
-	data view.ByteView
-}
\ No newline at end of file
+	key   string
+	data  view.ByteView
+	added time.Time
+	// 需要刷新的时间, 零值表示不需要刷新
+	refresh time.Time
+	// 过期时间, 零值表示永不过期
+	expire time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
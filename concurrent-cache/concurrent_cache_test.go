@@ -0,0 +1,94 @@
+package concurrentcache
+
+import (
+	"mini-cache/view"
+	"testing"
+	"time"
+)
+
+func TestGetExpired(t *testing.T) {
+	c := NewConcurrentCache(0)
+	defer c.Close()
+	c.AddWithTTL("key1", view.ByteView{B: []byte("1234")}, 20*time.Millisecond)
+	c.Add("key2", view.ByteView{B: []byte("5678")})
+
+	if v, ok := c.Get("key1"); !ok || v.String() != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("key1"); ok {
+		t.Fatalf("expired key1 should be a miss")
+	}
+	if _, ok := c.Get("key2"); !ok {
+		t.Fatalf("key2 without ttl should never expire")
+	}
+	if c.KeyCount() != 1 || c.UsedMemorySize() != 4 {
+		t.Fatalf("expired key1 should be removed, got %d keys %d bytes", c.KeyCount(), c.UsedMemorySize())
+	}
+}
+
+func TestGetStale(t *testing.T) {
+	c := NewConcurrentCache(0)
+	defer c.Close()
+	c.AddWithRefresh("key1", view.ByteView{B: []byte("1234")}, 10*time.Millisecond, 40*time.Millisecond)
+
+	item, ok := c.GetItem("key1")
+	if !ok || item.Stale(time.Now()) || item.Value.String() != "1234" {
+		t.Fatalf("key1 should be fresh")
+	}
+	time.Sleep(20 * time.Millisecond)
+	// 需要刷新但仍然可以读取
+	if item, ok = c.GetItem("key1"); !ok || !item.Stale(time.Now()) {
+		t.Fatalf("key1 should be stale")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok = c.GetItem("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	c := NewConcurrentCache(0)
+	defer c.Close()
+	c.StartSweeper(5 * time.Millisecond)
+	for _, k := range []string{"k1", "k2", "k3"} {
+		c.AddWithTTL(k, view.ByteView{B: []byte(k)}, 10*time.Millisecond)
+	}
+	c.Add("k4", view.ByteView{B: []byte("k4")})
+
+	time.Sleep(50 * time.Millisecond)
+	if c.KeyCount() != 1 {
+		t.Fatalf("sweeper should remove expired keys, %d keys left", c.KeyCount())
+	}
+}
+
+func TestRemoveOldest(t *testing.T) {
+	c := NewConcurrentCache(8)
+	c.Add("k1", view.ByteView{B: []byte("1234")})
+	c.Add("k2", view.ByteView{B: []byte("1234")})
+	c.Add("k3", view.ByteView{B: []byte("1234")})
+
+	if _, ok := c.Get("k1"); ok || c.KeyCount() != 2 {
+		t.Fatalf("RemoveOldest k1 failed")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	var evicted []string
+	c := NewConcurrentCache(8)
+	defer c.Close()
+	c.OnEvicted = func(key string, value view.ByteView) {
+		evicted = append(evicted, key+"="+value.String())
+	}
+	c.Add("k1", view.ByteView{B: []byte("1234")})
+	c.AddWithTTL("k2", view.ByteView{B: []byte("5678")}, 10*time.Millisecond)
+	c.Add("k3", view.ByteView{B: []byte("abcd")}) // 淘汰k1
+	c.Add("k3", view.ByteView{B: []byte("efgh")}) // 替换不调用
+	time.Sleep(20 * time.Millisecond)
+	c.Get("k2")    // 过期
+	c.Remove("k3") // 主动删除不调用
+
+	if len(evicted) != 2 || evicted[0] != "k1=1234" || evicted[1] != "k2=5678" {
+		t.Fatalf("unexpected evicted entries %v", evicted)
+	}
+}
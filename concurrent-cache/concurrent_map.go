@@ -7,11 +7,14 @@ import (
 
 var shard_count = 32
 
+// 计算分片使用的CRC32-Q表, 只需要生成一次
+var crcTable = crc32.MakeTable(0xD5828281)
+
 type concurrentMap []*concurrentMapShard
 
 // 分成shard_count个分片的map
 type concurrentMapShard struct {
-	items map[string]*node
+	items map[string]*entry
 	sync.RWMutex
 }
 
@@ -19,7 +22,7 @@ type concurrentMapShard struct {
 func newConcurrentMap() concurrentMap {
 	m := make(concurrentMap, shard_count)
 	for i := 0; i < shard_count; i++ {
-		m[i] = &concurrentMapShard{items: make(map[string]*node)}
+		m[i] = &concurrentMapShard{items: make(map[string]*entry)}
 	}
 	return m
 }
@@ -32,14 +35,13 @@ func newConcurrentMap() concurrentMap {
 // has the reversed notation 0b11010101100000101000001010000001, so the value
 // that should be passed to MakeTable is 0xD5828281.
 func (m concurrentMap) getShard(key string) *concurrentMapShard {
-	table := crc32.MakeTable(0xD5828281)
-	crc := crc32.Checksum([]byte(key), table)
+	crc := crc32.Checksum([]byte(key), crcTable)
 	return m[crc%uint32(shard_count)]
 
 	// crc = crc32.ChecksumIEEE([]byte(key))
 }
 
-func (m concurrentMap) set(key string, v *node) {
+func (m concurrentMap) set(key string, v *entry) {
 	// 根据key计算分片
 	shard := m.getShard(key)
 	shard.Lock()
@@ -48,7 +50,7 @@ func (m concurrentMap) set(key string, v *node) {
 	shard.Unlock()
 }
 
-func (m concurrentMap) get(key string) (v *node, ok bool) {
+func (m concurrentMap) get(key string) (v *entry, ok bool) {
 	// 根据key计算分片
 	shard := m.getShard(key)
 	shard.RLock()
@@ -64,3 +66,28 @@ func (m concurrentMap) delete(key string) {
 	delete(shard.items, key)
 	shard.Unlock()
 }
+
+// 只有当key对应的仍然是e时才删除, 防止误删并发写入的新数据
+func (m concurrentMap) deleteEntry(key string, e *entry) {
+	shard := m.getShard(key)
+	shard.Lock()
+	if v, ok := shard.items[key]; ok && v == e {
+		delete(shard.items, key)
+	}
+	shard.Unlock()
+}
+
+// 返回所有满足条件的数据, 逐个分片加读锁
+func (m concurrentMap) filter(fn func(e *entry) bool) []*entry {
+	var entries []*entry
+	for _, shard := range m {
+		shard.RLock()
+		for _, e := range shard.items {
+			if fn(e) {
+				entries = append(entries, e)
+			}
+		}
+		shard.RUnlock()
+	}
+	return entries
+}
@@ -0,0 +1,18 @@
+package concurrentcache
+
+// Policy 淘汰策略, 决定缓存超过容量之后淘汰哪一个key。
+// Policy 不需要是并发安全的, ConcurrentCache 调用时会加锁。
+// 对不存在的key调用 Access 和 Remove 时应当什么也不做。
+type Policy interface {
+	// Add 记录新加入或者被更新的key, size 为占用的内存
+	Add(key string, size uint64)
+	// Access 记录key被访问
+	Access(key string)
+	// Remove 删除key(过期或者主动删除)
+	Remove(key string)
+	// Evict 选出并删除一个应当被淘汰的key, 没有key时返回false
+	Evict() (key string, ok bool)
+}
+
+// NewPolicyFunc 创建淘汰策略, maxBytes 为缓存的容量
+type NewPolicyFunc func(maxBytes uint64) Policy
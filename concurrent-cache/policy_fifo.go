@@ -0,0 +1,44 @@
+package concurrentcache
+
+import "container/list"
+
+// 先进先出(First In First Out), 按照加入的顺序淘汰, 访问和更新不改变顺序
+type fifoPolicy struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewFIFO 创建FIFO淘汰策略
+func NewFIFO(maxBytes uint64) Policy {
+	return &fifoPolicy{
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (p *fifoPolicy) Add(key string, size uint64) {
+	if _, ok := p.items[key]; ok {
+		return
+	}
+	p.items[key] = p.ll.PushBack(key)
+}
+
+func (p *fifoPolicy) Access(key string) {}
+
+func (p *fifoPolicy) Remove(key string) {
+	if ele, ok := p.items[key]; ok {
+		p.ll.Remove(ele)
+		delete(p.items, key)
+	}
+}
+
+func (p *fifoPolicy) Evict() (string, bool) {
+	ele := p.ll.Front()
+	if ele == nil {
+		return "", false
+	}
+	key := ele.Value.(string)
+	p.ll.Remove(ele)
+	delete(p.items, key)
+	return key, true
+}
@@ -0,0 +1,89 @@
+package concurrentcache
+
+import "container/heap"
+
+// 最不经常访问(Least Frequently Used), 淘汰访问次数最少的key,
+// 访问次数相同时淘汰最久没有被访问的key
+type lfuPolicy struct {
+	h     lfuHeap
+	items map[string]*lfuItem
+	// 逻辑时钟, 每次访问加一
+	clock uint64
+}
+
+type lfuItem struct {
+	key   string
+	freq  uint64
+	tick  uint64 // 最近一次访问的时间
+	index int    // 在堆中的下标
+}
+
+// 按照(访问次数, 最近访问时间)排序的小顶堆
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// NewLFU 创建LFU淘汰策略
+func NewLFU(maxBytes uint64) Policy {
+	return &lfuPolicy{items: make(map[string]*lfuItem)}
+}
+
+func (p *lfuPolicy) Add(key string, size uint64) {
+	if _, ok := p.items[key]; ok {
+		p.Access(key)
+		return
+	}
+	p.clock++
+	item := &lfuItem{key: key, freq: 1, tick: p.clock}
+	p.items[key] = item
+	heap.Push(&p.h, item)
+}
+
+func (p *lfuPolicy) Access(key string) {
+	if item, ok := p.items[key]; ok {
+		p.clock++
+		item.freq++
+		item.tick = p.clock
+		heap.Fix(&p.h, item.index)
+	}
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	if item, ok := p.items[key]; ok {
+		heap.Remove(&p.h, item.index)
+		delete(p.items, key)
+	}
+}
+
+func (p *lfuPolicy) Evict() (string, bool) {
+	if p.h.Len() == 0 {
+		return "", false
+	}
+	item := heap.Pop(&p.h).(*lfuItem)
+	delete(p.items, item.key)
+	return item.key, true
+}
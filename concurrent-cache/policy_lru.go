@@ -0,0 +1,47 @@
+package concurrentcache
+
+import "mini-cache/achieve/lru"
+
+// 最近最少访问(Least Recently Used), 淘汰最久没有被访问的key
+type lruPolicy struct {
+	ll *lru.Cache
+	// 最近一次被淘汰的key, 由OnEvicted回调记录
+	evicted string
+}
+
+// lru.Value 只用来记录占用的内存
+type size uint64
+
+func (s size) Len() int {
+	return int(s)
+}
+
+// NewLRU 创建LRU淘汰策略
+func NewLRU(maxBytes uint64) Policy {
+	p := &lruPolicy{}
+	// 容量由 ConcurrentCache 控制, 这里不限制
+	p.ll = lru.New(0, func(key string, _ lru.Value) {
+		p.evicted = key
+	})
+	return p
+}
+
+func (p *lruPolicy) Add(key string, n uint64) {
+	p.ll.Add(key, size(n))
+}
+
+func (p *lruPolicy) Access(key string) {
+	p.ll.Get(key)
+}
+
+func (p *lruPolicy) Remove(key string) {
+	p.ll.Remove(key)
+}
+
+func (p *lruPolicy) Evict() (string, bool) {
+	if p.ll.Len() == 0 {
+		return "", false
+	}
+	p.ll.RemoveOldest()
+	return p.evicted, true
+}
@@ -0,0 +1,67 @@
+package concurrentcache
+
+import (
+	"mini-cache/view"
+	"reflect"
+	"testing"
+)
+
+func evictAll(p Policy) []string {
+	keys := make([]string, 0)
+	for {
+		key, ok := p.Evict()
+		if !ok {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+}
+
+func TestPolicyOrder(t *testing.T) {
+	testCases := map[string]struct {
+		newPolicy NewPolicyFunc
+		expect    []string
+	}{
+		// 按照最近一次访问的时间
+		"lru": {NewLRU, []string{"k2", "k3", "k1", "k4"}},
+		// 访问不改变顺序
+		"fifo": {NewFIFO, []string{"k1", "k2", "k3", "k4"}},
+		// k1 访问了3次, k3 访问了2次
+		"lfu": {NewLFU, []string{"k2", "k4", "k3", "k1"}},
+	}
+
+	for name, tc := range testCases {
+		p := tc.newPolicy(0)
+		p.Add("k1", 1)
+		p.Add("k2", 1)
+		p.Add("k3", 1)
+		p.Access("k1")
+		p.Access("k3")
+		p.Access("k1")
+		p.Add("k4", 1)
+		p.Add("k5", 1)
+		p.Remove("k5")
+		p.Access("unknown")
+		p.Remove("unknown")
+
+		if keys := evictAll(p); !reflect.DeepEqual(keys, tc.expect) {
+			t.Errorf("%s: expect eviction order %v, got %v", name, tc.expect, keys)
+		}
+	}
+}
+
+func TestCacheWithPolicy(t *testing.T) {
+	c := NewConcurrentCacheWithPolicy(8, NewFIFO)
+	c.Add("k1", view.ByteView{B: []byte("1234")})
+	c.Add("k2", view.ByteView{B: []byte("1234")})
+	c.Get("k1")
+	c.Add("k3", view.ByteView{B: []byte("1234")})
+
+	// FIFO 不关心访问, 仍然淘汰k1
+	if _, ok := c.Get("k1"); ok || c.KeyCount() != 2 || c.UsedMemorySize() != 8 {
+		t.Fatalf("FIFO should evict k1")
+	}
+	if _, ok := c.Get("k2"); !ok {
+		t.Fatalf("k2 should not be evicted")
+	}
+}
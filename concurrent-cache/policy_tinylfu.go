@@ -0,0 +1,217 @@
+package concurrentcache
+
+import "container/list"
+
+// W-TinyLFU (Window Tiny Least Frequently Used)
+//
+//	新数据 --> 窗口(LRU, 1%) --> 候选者 --> 准入判断 --> 主缓存(SLRU, 99%)
+//	                                          |            试用区(probation, 20%) --再次访问--> 保护区(protected, 80%)
+//	                                          |-- 候选者的访问频率不高于试用区最久没有访问的数据时, 淘汰候选者
+//
+// 访问频率由 count-min sketch 估计, 只出现过一次的key只记录在布隆过滤器(doorkeeper)中,
+// 计数达到采样上限后所有计数减半, 使旧的访问频率逐渐失效。
+// 大量只访问一次的数据(例如扫描)只会经过窗口, 无法挤掉主缓存中的热点数据。
+
+const (
+	// 窗口占总容量的比例
+	windowPercent = 1
+	// 保护区占主缓存的比例
+	protectedPercent = 80
+	// 估计计数器数量时假设的平均数据大小
+	avgEntryBytes = 64
+	minCounters   = 1 << 10
+	maxCounters   = 1 << 22
+)
+
+// 数据所在的区域
+type region int
+
+const (
+	windowRegion region = iota
+	candidateRegion
+	probationRegion
+	protectedRegion
+	regionCount
+)
+
+type tinyLFUItem struct {
+	key    string
+	hash   uint64
+	size   uint64
+	region region
+}
+
+type tinyLFUPolicy struct {
+	sketch *cmSketch
+	door   *doorkeeper
+	// 记录的访问次数, 达到 sampleSize 后重置
+	additions  uint64
+	sampleSize uint64
+
+	// 各区域的容量, 0 表示不限制
+	windowMax    uint64
+	mainMax      uint64
+	protectedMax uint64
+
+	// 每个区域一个链表, 链表头为最近访问的数据
+	lists [regionCount]*list.List
+	bytes [regionCount]uint64
+	items map[string]*list.Element
+}
+
+// NewTinyLFU 创建W-TinyLFU淘汰策略
+func NewTinyLFU(maxBytes uint64) Policy {
+	counters := maxBytes / avgEntryBytes
+	if counters < minCounters {
+		counters = minCounters
+	}
+	if counters > maxCounters {
+		counters = maxCounters
+	}
+	windowMax := maxBytes * windowPercent / 100
+	if maxBytes != 0 && windowMax == 0 {
+		windowMax = 1
+	}
+	p := &tinyLFUPolicy{
+		sketch:       newCMSketch(counters),
+		door:         newDoorkeeper(counters),
+		sampleSize:   counters * 10,
+		windowMax:    windowMax,
+		mainMax:      maxBytes - windowMax,
+		protectedMax: (maxBytes - windowMax) * protectedPercent / 100,
+		items:        make(map[string]*list.Element),
+	}
+	for i := range p.lists {
+		p.lists[i] = list.New()
+	}
+	return p
+}
+
+// 记录一次访问
+func (p *tinyLFUPolicy) record(h uint64) {
+	// 第一次出现只记录在布隆过滤器中
+	if p.door.add(h) {
+		p.sketch.increment(h)
+	}
+	p.additions++
+	if p.additions >= p.sampleSize {
+		p.sketch.reset()
+		p.door.reset()
+		p.additions = 0
+	}
+}
+
+// 估计访问频率
+func (p *tinyLFUPolicy) frequency(h uint64) int {
+	f := int(p.sketch.estimate(h))
+	if p.door.contains(h) {
+		f++
+	}
+	return f
+}
+
+// 移动到指定区域的链表头, 返回新的链表节点
+func (p *tinyLFUPolicy) move(ele *list.Element, to region) *list.Element {
+	item := p.lists[ele.Value.(*tinyLFUItem).region].Remove(ele).(*tinyLFUItem)
+	p.bytes[item.region] -= item.size
+	item.region = to
+	p.bytes[to] += item.size
+	ele = p.lists[to].PushFront(item)
+	p.items[item.key] = ele
+	return ele
+}
+
+// 从所有区域中删除
+func (p *tinyLFUPolicy) remove(ele *list.Element) string {
+	item := p.lists[ele.Value.(*tinyLFUItem).region].Remove(ele).(*tinyLFUItem)
+	p.bytes[item.region] -= item.size
+	delete(p.items, item.key)
+	return item.key
+}
+
+func (p *tinyLFUPolicy) mainBytes() uint64 {
+	return p.bytes[probationRegion] + p.bytes[protectedRegion]
+}
+
+func (p *tinyLFUPolicy) Add(key string, size uint64) {
+	if ele, ok := p.items[key]; ok {
+		item := ele.Value.(*tinyLFUItem)
+		p.bytes[item.region] = p.bytes[item.region] - item.size + size
+		item.size = size
+		p.Access(key)
+		return
+	}
+
+	h := hashKey(key)
+	p.record(h)
+	item := &tinyLFUItem{key: key, hash: h, size: size, region: windowRegion}
+	p.items[key] = p.lists[windowRegion].PushFront(item)
+	p.bytes[windowRegion] += size
+
+	// 窗口超过容量, 最久没有访问的数据成为候选者
+	for p.windowMax != 0 && p.bytes[windowRegion] > p.windowMax {
+		p.move(p.lists[windowRegion].Back(), candidateRegion)
+	}
+	// 主缓存还有空间时直接准入
+	for ele := p.lists[candidateRegion].Back(); ele != nil; ele = p.lists[candidateRegion].Back() {
+		if p.mainMax != 0 && p.mainBytes()+ele.Value.(*tinyLFUItem).size > p.mainMax {
+			break
+		}
+		p.move(ele, probationRegion)
+	}
+}
+
+func (p *tinyLFUPolicy) Access(key string) {
+	ele, ok := p.items[key]
+	if !ok {
+		return
+	}
+	item := ele.Value.(*tinyLFUItem)
+	p.record(item.hash)
+	switch item.region {
+	case probationRegion:
+		// 试用区的数据再次被访问, 晋升到保护区
+		p.move(ele, protectedRegion)
+		// 保护区超过容量, 最久没有访问的数据降级到试用区
+		for p.protectedMax != 0 && p.bytes[protectedRegion] > p.protectedMax && p.lists[protectedRegion].Len() > 1 {
+			p.move(p.lists[protectedRegion].Back(), probationRegion)
+		}
+	default:
+		p.lists[item.region].MoveToFront(ele)
+	}
+}
+
+func (p *tinyLFUPolicy) Remove(key string) {
+	if ele, ok := p.items[key]; ok {
+		p.remove(ele)
+	}
+}
+
+func (p *tinyLFUPolicy) Evict() (string, bool) {
+	candidate := p.lists[candidateRegion].Back()
+	victim := p.lists[probationRegion].Back()
+	if victim == nil {
+		victim = p.lists[protectedRegion].Back()
+	}
+
+	switch {
+	case candidate == nil && victim == nil:
+		// 只有窗口中有数据
+		if ele := p.lists[windowRegion].Back(); ele != nil {
+			return p.remove(ele), true
+		}
+		return "", false
+	case candidate == nil:
+		return p.remove(victim), true
+	case victim == nil:
+		return p.remove(candidate), true
+	}
+
+	// 准入判断: 候选者的访问频率更高时淘汰主缓存中的数据, 否则淘汰候选者
+	if p.frequency(candidate.Value.(*tinyLFUItem).hash) > p.frequency(victim.Value.(*tinyLFUItem).hash) {
+		key := p.remove(victim)
+		p.move(candidate, probationRegion)
+		return key, true
+	}
+	return p.remove(candidate), true
+}
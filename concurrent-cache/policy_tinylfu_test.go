@@ -0,0 +1,130 @@
+package concurrentcache
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"mini-cache/achieve/lru"
+	"mini-cache/view"
+	"os"
+	"testing"
+)
+
+const (
+	traceValueBytes = 16
+	traceKeyBytes   = 8
+	traceCapacity   = 500 // 缓存可以存放的数据个数
+)
+
+// 热点数据服从Zipf分布, 中间穿插只访问一次的扫描
+func scanTrace(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 10000)
+	trace := make([]string, 0, n)
+	scan := 0
+	for len(trace) < n {
+		if len(trace)%10000 == 0 {
+			// 扫描的数据量是缓存容量的两倍
+			for i := 0; i < 2*traceCapacity; i++ {
+				trace = append(trace, fmt.Sprintf("s%07d", scan))
+				scan++
+			}
+		}
+		trace = append(trace, fmt.Sprintf("h%07d", zipf.Uint64()))
+	}
+	return trace
+}
+
+// 读取一行一个key的访问记录
+func readTrace(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var trace []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		trace = append(trace, scanner.Text())
+	}
+	return trace, scanner.Err()
+}
+
+func hitRatio(trace []string, get func(string) bool, add func(string)) float64 {
+	hits := 0
+	for _, key := range trace {
+		if get(key) {
+			hits++
+		} else {
+			add(key)
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+// 返回 W-TinyLFU 和 achieve/lru 的命中率
+func replay(trace []string) (float64, float64) {
+	value := view.ByteView{B: make([]byte, traceValueBytes)}
+	c := NewConcurrentCacheWithPolicy(traceCapacity*traceValueBytes, NewTinyLFU)
+	tinyLFU := hitRatio(trace,
+		func(key string) bool { _, ok := c.Get(key); return ok },
+		func(key string) { c.Add(key, value) })
+
+	// lru.Cache 的内存统计包括key
+	l := lru.New(traceCapacity*(traceKeyBytes+traceValueBytes), nil)
+	lruRatio := hitRatio(trace,
+		func(key string) bool { _, ok := l.Get(key); return ok },
+		func(key string) { l.Add(key, size(traceValueBytes)) })
+	return tinyLFU, lruRatio
+}
+
+func TestTinyLFUHitRatio(t *testing.T) {
+	tinyLFU, lruRatio := replay(scanTrace(200000))
+	t.Logf("scan trace hit ratio: W-TinyLFU %.4f, LRU %.4f", tinyLFU, lruRatio)
+	if tinyLFU <= lruRatio {
+		t.Fatalf("W-TinyLFU hit ratio %.4f should be higher than LRU %.4f", tinyLFU, lruRatio)
+	}
+
+	// 可以通过环境变量指定其他访问记录重放
+	if path := os.Getenv("CACHE_TRACE"); path != "" {
+		trace, err := readTrace(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tinyLFU, lruRatio := replay(trace)
+		t.Logf("%s hit ratio: W-TinyLFU %.4f, LRU %.4f", path, tinyLFU, lruRatio)
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	p := NewTinyLFU(100)
+	// 热点数据进入主缓存
+	for i := 0; i < 5; i++ {
+		p.Add("hot", 50)
+		p.Access("hot")
+	}
+	p.Add("warm", 40)
+	// 只访问一次的新数据成为候选者, 访问频率不如主缓存中的数据, 被淘汰
+	p.Add("cold", 40)
+	if key, ok := p.Evict(); !ok || key != "cold" {
+		t.Fatalf("expect cold to be rejected, got %s", key)
+	}
+	p.Remove("hot")
+	p.Remove("warm")
+	if key, ok := p.Evict(); ok {
+		t.Fatalf("policy should be empty, got %s", key)
+	}
+}
+
+func BenchmarkTinyLFU(b *testing.B) {
+	trace := scanTrace(100000)
+	value := view.ByteView{B: make([]byte, traceValueBytes)}
+	c := NewConcurrentCacheWithPolicy(traceCapacity*traceValueBytes, NewTinyLFU)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := trace[i%len(trace)]
+		if _, ok := c.Get(key); !ok {
+			c.Add(key, value)
+		}
+	}
+}
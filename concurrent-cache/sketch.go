@@ -0,0 +1,126 @@
+package concurrentcache
+
+// TinyLFU 使用的频率统计: count-min sketch 和作为门卫(doorkeeper)的布隆过滤器
+
+const (
+	// count-min sketch 的行数
+	sketchDepth = 4
+	// 4 bit 计数器的最大值
+	maxCount = 15
+)
+
+// 64位 FNV-1a 哈希
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// 双重哈希, 根据同一个哈希值得到第i个下标
+func hashIndex(h uint64, i int, mask uint64) uint64 {
+	h1, h2 := h, (h>>32)|1
+	return (h1 + uint64(i)*h2) & mask
+}
+
+// 向上取2的幂
+func nextPowerOfTwo(n uint64) uint64 {
+	p := uint64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// count-min sketch, 每个计数器为4 bit, 两个计数器存放在一个字节中
+type cmSketch struct {
+	rows [sketchDepth][]byte
+	mask uint64
+}
+
+func newCMSketch(width uint64) *cmSketch {
+	width = nextPowerOfTwo(width)
+	s := &cmSketch{mask: width - 1}
+	for i := range s.rows {
+		s.rows[i] = make([]byte, width/2+1)
+	}
+	return s
+}
+
+func (s *cmSketch) get(row int, idx uint64) byte {
+	return (s.rows[row][idx/2] >> ((idx & 1) * 4)) & 0x0f
+}
+
+func (s *cmSketch) increment(h uint64) {
+	for i := range s.rows {
+		idx := hashIndex(h, i, s.mask)
+		if s.get(i, idx) < maxCount {
+			s.rows[i][idx/2] += 1 << ((idx & 1) * 4)
+		}
+	}
+}
+
+// 所有行中最小的计数
+func (s *cmSketch) estimate(h uint64) byte {
+	min := byte(maxCount)
+	for i := range s.rows {
+		if c := s.get(i, hashIndex(h, i, s.mask)); c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// 所有计数减半, 使旧的访问频率逐渐失效
+func (s *cmSketch) reset() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] = (row[i] >> 1) & 0x77
+		}
+	}
+}
+
+// 布隆过滤器, 只出现过一次的key不会进入 count-min sketch
+type doorkeeper struct {
+	bits []uint64
+	mask uint64
+}
+
+func newDoorkeeper(size uint64) *doorkeeper {
+	size = nextPowerOfTwo(size)
+	if size < 64 {
+		size = 64
+	}
+	return &doorkeeper{bits: make([]uint64, size/64), mask: size - 1}
+}
+
+// 添加key, 返回key之前是否已经存在
+func (d *doorkeeper) add(h uint64) bool {
+	exist := true
+	for i := 0; i < sketchDepth; i++ {
+		idx := hashIndex(h, i, d.mask)
+		if d.bits[idx/64]&(1<<(idx%64)) == 0 {
+			exist = false
+			d.bits[idx/64] |= 1 << (idx % 64)
+		}
+	}
+	return exist
+}
+
+func (d *doorkeeper) contains(h uint64) bool {
+	for i := 0; i < sketchDepth; i++ {
+		idx := hashIndex(h, i, d.mask)
+		if d.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *doorkeeper) reset() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+}
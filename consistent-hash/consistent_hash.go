@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -15,6 +16,7 @@ type Hash func(data []byte) uint32
 // func (u uint_32) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
 // func (u uint_32) Less(i, j int) bool { return u[i] < u[j] }
 
+// Pool Ketama 风格的一致性Hash环, 每个真实节点对应 replicas 个虚拟节点
 type Pool struct {
 	// Hash func
 	hash Hash
@@ -25,6 +27,14 @@ type Pool struct {
 	// 虚拟节点的Hash值映射到真实节点, 真实节点不放在环上
 	// virtual nodes map to real nodes
 	vMapToR map[int]string
+	// 已经添加的真实节点
+	peers map[string]bool
+
+	// 有界负载: 每个节点的负载不超过平均负载的 loadFactor 倍, 为0时不限制
+	loadFactor float64
+	// 每个真实节点当前的负载, 以及所有节点的负载之和
+	loads     map[string]int64
+	totalLoad int64
 }
 
 func New(replicas int, fn Hash) *Pool {
@@ -33,6 +43,8 @@ func New(replicas int, fn Hash) *Pool {
 		hash:         fn,
 		vMapToR:      make(map[int]string),
 		virtualNodes: make([]int, 0),
+		peers:        make(map[string]bool),
+		loads:        make(map[string]int64),
 	}
 	if m.hash == nil {
 		// 默认的Hash算法
@@ -41,9 +53,13 @@ func New(replicas int, fn Hash) *Pool {
 	return m
 }
 
-// 添加真实节点
+// 添加真实节点, 已经存在的节点会被忽略
 func (p *Pool) Add(peers ...string) {
 	for _, peer := range peers {
+		if p.peers[peer] {
+			continue
+		}
+		p.peers[peer] = true
 		for i := 0; i < p.replicas; i++ {
 			// 通过添加编号的方式来区分虚拟节点
 			hash := int(p.hash([]byte(strconv.Itoa(i) + peer)))
@@ -54,19 +70,154 @@ func (p *Pool) Add(peers ...string) {
 	sort.Ints(p.virtualNodes)
 }
 
+// Remove 删除真实节点以及它的所有虚拟节点, 只有原本属于这个节点的key会被重新分配
+func (p *Pool) Remove(peer string) {
+	if !p.peers[peer] {
+		return
+	}
+	delete(p.peers, peer)
+	p.totalLoad -= p.loads[peer]
+	delete(p.loads, peer)
+	virtualNodes := p.virtualNodes[:0]
+	for _, hash := range p.virtualNodes {
+		if p.vMapToR[hash] == peer {
+			delete(p.vMapToR, hash)
+			continue
+		}
+		virtualNodes = append(virtualNodes, hash)
+	}
+	p.virtualNodes = virtualNodes
+}
+
+// Peers 返回所有的真实节点
+func (p *Pool) Peers() []string {
+	peers := make([]string, 0, len(p.peers))
+	for peer := range p.peers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
 // Get 获取在hash环上距离key最近的节点。
 func (p *Pool) Get(key string) string {
 	if len(p.virtualNodes) == 0 {
 		// hash环上没有节点
 		return ""
 	}
-	// key的hash值
-	hash := int(p.hash([]byte(key)))
-	// 二分搜索最近的虚拟节点的下标
-	idx := sort.Search(len(p.virtualNodes), func(i int) bool { return p.virtualNodes[i] >= hash })
+	idx := p.search(key)
 
 	// 将虚拟节点转换成为真实节点, 这里idx取余数的原因是可能 sort.Search() 搜索不到下标。然后就返回[0,n)的n。所以需要取余数，构成hash环
 	peer := p.vMapToR[p.virtualNodes[idx%len(p.virtualNodes)]]
 
 	return peer
 }
+
+// GetN 从key的位置沿着hash环顺时针查找, 返回最多n个不同的真实节点, 第一个与 Get 的结果相同。
+// 用于把key复制到多个节点上, 某个节点故障时由后面的节点继续提供服务。
+func (p *Pool) GetN(key string, n int) []string {
+	if len(p.virtualNodes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(p.peers) {
+		n = len(p.peers)
+	}
+	peers := make([]string, 0, n)
+	idx := p.search(key)
+	for i := 0; i < len(p.virtualNodes) && len(peers) < n; i++ {
+		peer := p.vMapToR[p.virtualNodes[(idx+i)%len(p.virtualNodes)]]
+		if !contains(peers, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func contains(peers []string, peer string) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// 二分搜索距离key最近的虚拟节点的下标, 可能等于 len(p.virtualNodes)
+func (p *Pool) search(key string) int {
+	// key的hash值
+	hash := int(p.hash([]byte(key)))
+	return sort.Search(len(p.virtualNodes), func(i int) bool { return p.virtualNodes[i] >= hash })
+}
+
+/*
+	有界负载的一致性哈希 (Consistent Hashing with Bounded Loads):
+	每个节点的负载上限为 ceil((1+ε) * (总负载+1) / 节点数量),
+	key先找到环上最近的节点, 节点已经达到上限时沿着环顺时针找下一个节点。
+	热点key的请求会溢出到相邻的节点, 而不是全部压在同一个节点上;
+	负载没有达到上限时与 Get 的结果相同。
+*/
+
+// SetLoadBound 开启有界负载, 每个节点的负载不超过平均负载的 (1+epsilon) 倍, epsilon <= 0 时关闭
+func (p *Pool) SetLoadBound(epsilon float64) {
+	if epsilon <= 0 {
+		p.loadFactor = 0
+		return
+	}
+	p.loadFactor = 1 + epsilon
+}
+
+// MaxLoad 返回再增加一个负载之后每个节点允许的最大负载, 没有开启有界负载时返回0
+func (p *Pool) MaxLoad() int64 {
+	if p.loadFactor == 0 || len(p.peers) == 0 {
+		return 0
+	}
+	avg := float64(p.totalLoad+1) / float64(len(p.peers))
+	return int64(math.Ceil(avg * p.loadFactor))
+}
+
+// GetLeast 与 Get 相同, 但是跳过已经达到负载上限的节点。没有开启有界负载时等同于 Get
+func (p *Pool) GetLeast(key string) string {
+	if len(p.virtualNodes) == 0 {
+		return ""
+	}
+	maxLoad := p.MaxLoad()
+	idx := p.search(key)
+	if maxLoad == 0 {
+		return p.vMapToR[p.virtualNodes[idx%len(p.virtualNodes)]]
+	}
+	for i := 0; i < len(p.virtualNodes); i++ {
+		peer := p.vMapToR[p.virtualNodes[(idx+i)%len(p.virtualNodes)]]
+		if p.loads[peer]+1 <= maxLoad {
+			return peer
+		}
+	}
+	// 上限大于平均负载, 至少有一个节点没有达到上限, 不会执行到这里
+	return p.vMapToR[p.virtualNodes[idx%len(p.virtualNodes)]]
+}
+
+// Inc 节点开始处理一个请求, 不存在的节点会被忽略
+func (p *Pool) Inc(peer string) {
+	if !p.peers[peer] {
+		return
+	}
+	p.loads[peer]++
+	p.totalLoad++
+}
+
+// Done 节点处理完一个请求
+func (p *Pool) Done(peer string) {
+	if p.loads[peer] <= 0 {
+		return
+	}
+	p.loads[peer]--
+	p.totalLoad--
+}
+
+// Loads 返回每个节点当前的负载
+func (p *Pool) Loads() map[string]int64 {
+	loads := make(map[string]int64, len(p.peers))
+	for peer := range p.peers {
+		loads[peer] = p.loads[peer]
+	}
+	return loads
+}
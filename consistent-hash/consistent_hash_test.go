@@ -41,4 +41,140 @@ func TestHashing(t *testing.T) {
 		}
 	}
 
-}
\ No newline at end of file
+}
+func TestRemove(t *testing.T) {
+	hash := consistenthash.New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	// 重复添加不会产生重复的虚拟节点
+	hash.Add("4")
+	hash.Remove("4")
+	hash.Remove("unknown")
+
+	// 剩下 2, 6, 12, 16, 22, 26
+	testCases := map[string]string{
+		"2":  "2",
+		"3":  "6",
+		"13": "6",
+		"23": "6",
+		"27": "2",
+	}
+
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+	if peers := hash.Peers(); len(peers) != 2 {
+		t.Errorf("expect 2 peers, got %v", peers)
+	}
+}
+
+// 删除或添加一个节点时, 只有大约 1/N 的key会被重新分配
+func TestMovement(t *testing.T) {
+	const peerCount, keyCount = 10, 10000
+	hash := consistenthash.New(50, nil)
+	for i := 0; i < peerCount; i++ {
+		hash.Add("peer" + strconv.Itoa(i))
+	}
+	before := make(map[string]string, keyCount)
+	for i := 0; i < keyCount; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = hash.Get(key)
+	}
+
+	// 删除一个节点, 只有原本属于它的key会移动
+	hash.Remove("peer3")
+	moved := 0
+	for key, peer := range before {
+		now := hash.Get(key)
+		if now == peer {
+			continue
+		}
+		if peer != "peer3" {
+			t.Fatalf("key %s moved from %s to %s", key, peer, now)
+		}
+		moved++
+	}
+	if ratio := float64(moved) / keyCount; ratio > 2.0/peerCount {
+		t.Errorf("removing a peer moved %.2f of keys", ratio)
+	}
+
+	// 重新加入后, 只有移动过的key回到原来的节点
+	hash.Add("peer3")
+	for key, peer := range before {
+		if now := hash.Get(key); now != peer {
+			t.Fatalf("key %s should move back to %s, got %s", key, peer, now)
+		}
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	hash := consistenthash.New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	hash.SetLoadBound(0.25)
+
+	// 11 属于 2, 2 达到上限之后溢出到环上的下一个节点 4
+	for i, expect := range []string{"2", "4", "2", "4", "2", "4", "6"} {
+		peer := hash.GetLeast("11")
+		if peer != expect {
+			t.Fatalf("request %d: expect %s, got %s (loads %v)", i, expect, peer, hash.Loads())
+		}
+		hash.Inc(peer)
+	}
+	if hash.Get("11") != "2" {
+		t.Fatalf("Get should ignore loads")
+	}
+
+	// 负载降低之后回到原来的节点
+	hash.Done("2")
+	hash.Done("2")
+	if peer := hash.GetLeast("11"); peer != "2" {
+		t.Fatalf("expect 2 after loads drop, got %s", peer)
+	}
+
+	// 删除节点时它的负载一起删除
+	hash.Remove("4")
+	if loads := hash.Loads(); len(loads) != 2 || loads["6"] != 1 || hash.MaxLoad() != 2 {
+		t.Fatalf("unexpected loads %v, max %d", loads, hash.MaxLoad())
+	}
+
+	hash.SetLoadBound(0)
+	hash.Inc("2")
+	hash.Inc("2")
+	if peer := hash.GetLeast("11"); peer != "2" {
+		t.Fatalf("unbounded GetLeast should equal Get, got %s", peer)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := consistenthash.New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := map[string][]string{
+		"11": {"2", "4"},
+		"23": {"4", "6"},
+		"27": {"2", "4"},
+	}
+	for k, v := range testCases {
+		if peers := hash.GetN(k, 2); len(peers) != 2 || peers[0] != v[0] || peers[1] != v[1] {
+			t.Errorf("Asking for %s, should have yielded %v, got %v", k, v, peers)
+		}
+	}
+	// 最多返回所有的真实节点
+	if peers := hash.GetN("11", 5); len(peers) != 3 {
+		t.Errorf("expect 3 peers, got %v", peers)
+	}
+}
@@ -0,0 +1,77 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+/*
+	Jump consistent hash (Lamping & Veach):
+	不需要额外的内存, 查找的时间复杂度为 O(log N), 分布几乎完全均匀。
+	桶只能在末尾增加和删除: 添加的节点放在末尾; 删除中间的节点时用最后一个节点填补它的位置,
+	因此除了被删除节点的key以外, 原来属于最后一个节点的key也会移动。
+*/
+
+type Jump struct {
+	hash Hash
+	// 节点按照添加的顺序排列, 下标即桶的编号
+	buckets []string
+}
+
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Jump{hash: fn}
+}
+
+func (j *Jump) index(peer string) int {
+	for i, p := range j.buckets {
+		if p == peer {
+			return i
+		}
+	}
+	return -1
+}
+
+func (j *Jump) Add(peers ...string) {
+	for _, peer := range peers {
+		if j.index(peer) < 0 {
+			j.buckets = append(j.buckets, peer)
+		}
+	}
+}
+
+func (j *Jump) Remove(peer string) {
+	idx := j.index(peer)
+	if idx < 0 {
+		return
+	}
+	last := len(j.buckets) - 1
+	j.buckets[idx] = j.buckets[last]
+	j.buckets = j.buckets[:last]
+}
+
+func (j *Jump) Peers() []string {
+	peers := append([]string(nil), j.buckets...)
+	sort.Strings(peers)
+	return peers
+}
+
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(mix64(uint64(j.hash([]byte(key)))), len(j.buckets))]
+}
+
+// 论文中的算法, 返回 [0, buckets) 中的桶编号
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
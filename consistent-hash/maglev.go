@@ -0,0 +1,131 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+/*
+	Maglev hashing (Google Maglev 负载均衡器):
+	每个节点根据自己的hash生成一个 [0, M) 的排列, 节点轮流按照排列填充长度为 M 的查找表,
+	查找时只需要一次取模, 时间复杂度为 O(1), 每个节点在表中的位置数量最多相差1。
+	节点变化时需要重建查找表 O(M*N), 并且会有少量不属于被删除节点的key移动。
+*/
+
+// 查找表的默认大小, 需要是质数并且远大于节点数量
+const defaultMaglevTableSize = 65537
+
+type Maglev struct {
+	hash Hash
+	// 查找表的大小, 质数
+	size uint64
+	// 已经排序的真实节点
+	peers []string
+	// 查找表, 值为节点在peers中的下标
+	table []int
+}
+
+// NewMaglev size 为查找表的大小, 为0时使用 65537。
+// 查找表的大小必须是质数, 否则节点的排列不能覆盖整个表; size 不是质数时使用不小于它的最小质数
+func NewMaglev(size int, fn Hash) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevTableSize
+	}
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Maglev{hash: fn, size: nextPrime(uint64(size))}
+}
+
+// 不小于n的最小质数
+func nextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for d := uint64(2); d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+func (m *Maglev) Add(peers ...string) {
+	changed := false
+	for _, peer := range peers {
+		idx := sort.SearchStrings(m.peers, peer)
+		if idx < len(m.peers) && m.peers[idx] == peer {
+			continue
+		}
+		m.peers = append(m.peers, "")
+		copy(m.peers[idx+1:], m.peers[idx:])
+		m.peers[idx] = peer
+		changed = true
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *Maglev) Remove(peer string) {
+	idx := sort.SearchStrings(m.peers, peer)
+	if idx == len(m.peers) || m.peers[idx] != peer {
+		return
+	}
+	m.peers = append(m.peers[:idx], m.peers[idx+1:]...)
+	m.populate()
+}
+
+func (m *Maglev) Peers() []string {
+	return append([]string(nil), m.peers...)
+}
+
+func (m *Maglev) Get(key string) string {
+	if len(m.peers) == 0 {
+		return ""
+	}
+	return m.peers[m.table[uint64(m.hash([]byte(key)))%m.size]]
+}
+
+// 重建查找表
+func (m *Maglev) populate() {
+	if len(m.peers) == 0 {
+		m.table = nil
+		return
+	}
+	// 每个节点的排列为 (offset + i*skip) % size
+	offsets := make([]uint64, len(m.peers))
+	skips := make([]uint64, len(m.peers))
+	for i, peer := range m.peers {
+		h := mix64(uint64(m.hash([]byte(peer))))
+		offsets[i] = (h >> 32) % m.size
+		skips[i] = (h&0xffffffff)%(m.size-1) + 1
+	}
+	next := make([]uint64, len(m.peers))
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := uint64(0); ; {
+		for i := range m.peers {
+			// 找到当前节点排列中下一个空位
+			c := (offsets[i] + next[i]*skips[i]) % m.size
+			for table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % m.size
+			}
+			table[c] = i
+			next[i]++
+			if filled++; filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package consistenthash
+
+// Picker 把key映射到真实节点, 不是并发安全的, 由调用方加锁
+type Picker interface {
+	// 添加真实节点, 已经存在的节点会被忽略
+	Add(peers ...string)
+	// 删除真实节点, 不存在的节点会被忽略
+	Remove(peer string)
+	// 返回所有的真实节点, 已经排序
+	Peers() []string
+	// 返回key所属的节点, 没有节点时返回 ""
+	Get(key string) string
+}
+
+// BoundedPicker 支持有界负载的 Picker, 见 Pool.GetLeast
+type BoundedPicker interface {
+	Picker
+	SetLoadBound(epsilon float64)
+	GetLeast(key string) string
+	Inc(peer string)
+	Done(peer string)
+	Loads() map[string]int64
+}
+
+// ReplicaPicker 可以为key选择多个节点的 Picker
+type ReplicaPicker interface {
+	Picker
+	// 按照优先级返回最多n个不同的节点, 第一个与 Get 的结果相同
+	GetN(key string, n int) []string
+}
+
+var (
+	_ BoundedPicker = (*Pool)(nil)
+	_ ReplicaPicker = (*Pool)(nil)
+	_ ReplicaPicker = (*Rendezvous)(nil)
+	_ Picker        = (*Jump)(nil)
+	_ Picker        = (*Maglev)(nil)
+)
+
+// 把32位的hash值打散到64位 (splitmix64), 弥补 crc32 这类hash分布上的不足
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,185 @@
+package consistenthash_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	consistenthash "mini-cache/consistent-hash"
+)
+
+var pickers = []struct {
+	name string
+	new  func() consistenthash.Picker
+	// 节点的key数量与平均值的最大偏差
+	maxSkew float64
+	// 删除一个节点时, 不属于它的key最多移动的比例
+	maxForeign float64
+}{
+	{"ring", func() consistenthash.Picker { return consistenthash.New(50, nil) }, 0.5, 0},
+	{"rendezvous", func() consistenthash.Picker { return consistenthash.NewRendezvous(nil) }, 0.1, 0},
+	// 最后一个节点填补被删除节点的位置
+	{"jump", func() consistenthash.Picker { return consistenthash.NewJump(nil) }, 0.1, 0.15},
+	{"maglev", func() consistenthash.Picker { return consistenthash.NewMaglev(0, nil) }, 0.1, 0.05},
+}
+
+func newPicker(new func() consistenthash.Picker, peers int) consistenthash.Picker {
+	p := new()
+	for i := 0; i < peers; i++ {
+		p.Add("peer" + strconv.Itoa(i))
+	}
+	return p
+}
+
+func TestPickerEmpty(t *testing.T) {
+	for _, tc := range pickers {
+		p := tc.new()
+		if peer := p.Get("key"); peer != "" {
+			t.Errorf("%s: empty picker should return \"\", got %s", tc.name, peer)
+		}
+		p.Add("a", "b", "a")
+		p.Remove("unknown")
+		if peers := p.Peers(); len(peers) != 2 || peers[0] != "a" || peers[1] != "b" {
+			t.Errorf("%s: unexpected peers %v", tc.name, peers)
+		}
+		p.Remove("a")
+		p.Remove("b")
+		if peer := p.Get("key"); peer != "" {
+			t.Errorf("%s: picker should be empty after removing all peers, got %s", tc.name, peer)
+		}
+	}
+}
+
+// 每个节点分到的key数量接近平均值
+func TestPickerDistribution(t *testing.T) {
+	const peerCount, keyCount = 10, 100000
+	for _, tc := range pickers {
+		p := newPicker(tc.new, peerCount)
+		counts := make(map[string]int)
+		for i := 0; i < keyCount; i++ {
+			counts[p.Get("key"+strconv.Itoa(i))]++
+		}
+		if len(counts) != peerCount {
+			t.Fatalf("%s: keys should spread over all peers, got %v", tc.name, counts)
+		}
+		mean := float64(keyCount) / peerCount
+		skew := 0.0
+		for _, n := range counts {
+			skew = math.Max(skew, math.Abs(float64(n)-mean)/mean)
+		}
+		t.Logf("%s: max skew %.3f", tc.name, skew)
+		if skew > tc.maxSkew {
+			t.Errorf("%s: max skew %.3f > %.3f, counts %v", tc.name, skew, tc.maxSkew, counts)
+		}
+	}
+}
+
+// 删除或添加节点时只有少量的key移动
+func TestPickerMovement(t *testing.T) {
+	const peerCount, keyCount = 10, 10000
+	for _, tc := range pickers {
+		p := newPicker(tc.new, peerCount)
+		before := make(map[string]string, keyCount)
+		for i := 0; i < keyCount; i++ {
+			key := "key" + strconv.Itoa(i)
+			before[key] = p.Get(key)
+		}
+
+		p.Remove("peer3")
+		foreign := 0
+		for key, peer := range before {
+			now := p.Get(key)
+			if now == "peer3" {
+				t.Fatalf("%s: key %s still maps to the removed peer", tc.name, key)
+			}
+			if peer != "peer3" && now != peer {
+				foreign++
+			}
+		}
+		ratio := float64(foreign) / keyCount
+		t.Logf("%s: %.3f of other keys moved", tc.name, ratio)
+		if ratio > tc.maxForeign {
+			t.Errorf("%s: removing a peer moved %.3f of other keys", tc.name, ratio)
+		}
+
+		// 在末尾添加节点, 大部分移动的key都移动到新节点
+		p.Add("peer10")
+		p2 := newPicker(tc.new, peerCount)
+		p2.Remove("peer3")
+		moved, foreign := 0, 0
+		for key := range before {
+			if now, old := p.Get(key), p2.Get(key); now != old {
+				moved++
+				if now != "peer10" {
+					foreign++
+				}
+			}
+		}
+		if ratio := float64(foreign) / keyCount; ratio > tc.maxForeign {
+			t.Errorf("%s: adding a peer moved %.3f of other keys", tc.name, ratio)
+		}
+		if ratio := float64(moved) / keyCount; ratio > 2.0/peerCount+tc.maxForeign {
+			t.Errorf("%s: adding a peer moved %.3f of keys", tc.name, ratio)
+		}
+	}
+}
+
+func BenchmarkPickerGet(b *testing.B) {
+	for _, tc := range pickers {
+		for _, peers := range []int{10, 100} {
+			p := newPicker(tc.new, peers)
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = "key" + strconv.Itoa(i)
+			}
+			b.Run(tc.name+"/"+strconv.Itoa(peers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.Get(keys[i&1023])
+				}
+			})
+		}
+	}
+}
+
+// 第一个节点故障之后, 原来的第二个副本成为新的第一个副本
+func TestReplicaPickerFailover(t *testing.T) {
+	for _, tc := range pickers {
+		p, ok := newPicker(tc.new, 10).(consistenthash.ReplicaPicker)
+		if !ok {
+			continue
+		}
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			replicas := p.GetN(key, 3)
+			if len(replicas) != 3 || replicas[0] != p.Get(key) {
+				t.Fatalf("%s: unexpected replicas %v of %s", tc.name, replicas, key)
+			}
+			if replicas[1] == replicas[0] || replicas[2] == replicas[0] || replicas[1] == replicas[2] {
+				t.Fatalf("%s: replicas should be distinct, got %v", tc.name, replicas)
+			}
+			p.Remove(replicas[0])
+			if now := p.GetN(key, 2); now[0] != replicas[1] || now[1] != replicas[2] {
+				t.Fatalf("%s: expect %v after removing %s, got %v", tc.name, replicas[1:], replicas[0], now)
+			}
+			p.Add(replicas[0])
+		}
+	}
+}
+
+// 查找表的大小不是质数时也可以正常使用
+func TestMaglevTableSize(t *testing.T) {
+	for _, size := range []int{1, 2, 4, 100} {
+		p := consistenthash.NewMaglev(size, nil)
+		p.Add("a", "b", "c")
+		seen := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			seen[p.Get("key"+strconv.Itoa(i))] = true
+		}
+		if size > 2 && len(seen) != 3 {
+			t.Errorf("size %d: every peer should own keys, got %v", size, seen)
+		}
+		if seen[""] {
+			t.Errorf("size %d: Get should always return a peer", size)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+/*
+	Rendezvous hashing (Highest Random Weight):
+	key 和每个节点组合计算一个分数, 分数最高的节点负责这个key。
+	不需要虚拟节点, 分布均匀, 删除节点时只有属于它的key会移动; 查找的时间复杂度为 O(N)。
+*/
+
+type Rendezvous struct {
+	hash Hash
+	// 已经排序的真实节点和它们的hash值
+	peers  []string
+	hashes []uint32
+}
+
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Rendezvous{hash: fn}
+}
+
+func (r *Rendezvous) Add(peers ...string) {
+	for _, peer := range peers {
+		idx := sort.SearchStrings(r.peers, peer)
+		if idx < len(r.peers) && r.peers[idx] == peer {
+			continue
+		}
+		r.peers = append(r.peers, "")
+		copy(r.peers[idx+1:], r.peers[idx:])
+		r.peers[idx] = peer
+		r.hashes = append(r.hashes, 0)
+		copy(r.hashes[idx+1:], r.hashes[idx:])
+		r.hashes[idx] = r.hash([]byte(peer))
+	}
+}
+
+func (r *Rendezvous) Remove(peer string) {
+	idx := sort.SearchStrings(r.peers, peer)
+	if idx == len(r.peers) || r.peers[idx] != peer {
+		return
+	}
+	r.peers = append(r.peers[:idx], r.peers[idx+1:]...)
+	r.hashes = append(r.hashes[:idx], r.hashes[idx+1:]...)
+}
+
+func (r *Rendezvous) Peers() []string {
+	return append([]string(nil), r.peers...)
+}
+
+func (r *Rendezvous) Get(key string) string {
+	if len(r.peers) == 0 {
+		return ""
+	}
+	k := r.keyHash(key)
+	best, bestScore := 0, uint64(0)
+	for i, h := range r.hashes {
+		if score := mix64(k | uint64(h)); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return r.peers[best]
+}
+
+// GetN 返回分数最高的最多n个节点, 分数从高到低排列, 第一个与 Get 的结果相同
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if n <= 0 || len(r.peers) == 0 {
+		return nil
+	}
+	k := r.keyHash(key)
+	idx := make([]int, len(r.peers))
+	scores := make([]uint64, len(r.peers))
+	for i, h := range r.hashes {
+		idx[i] = i
+		scores[i] = mix64(k | uint64(h))
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return scores[idx[a]] > scores[idx[b]] })
+	if n > len(idx) {
+		n = len(idx)
+	}
+	peers := make([]string, n)
+	for i := range peers {
+		peers[i] = r.peers[idx[i]]
+	}
+	return peers
+}
+
+// key只计算一次hash, 与节点的hash组合之后打散作为分数
+func (r *Rendezvous) keyHash(key string) uint64 {
+	return uint64(r.hash([]byte(key))) << 32
+}
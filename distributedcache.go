@@ -1,12 +1,15 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"log"
+	"math/rand"
 	concurrentcache "mini-cache/concurrent-cache"
 	"mini-cache/singleflight"
 	"mini-cache/view"
 	"sync"
+	"time"
 
 	pb "mini-cache/proto"
 )
@@ -47,6 +50,48 @@ func (f GettrFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// ContextGettr 与 Gettr 相同, 但回调时会传入调用方的 context,
+// 回调函数可以据此感知调用方的超时与取消。
+type ContextGettr interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// 定义带 context 的回调函数，实现ContextGettr接口
+type ContextGettrFunc func(ctx context.Context, key string) ([]byte, error)
+
+// 实现ContextGettr接口
+func (f ContextGettrFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// ToContextGettr 将不感知 context 的 Gettr (例如 GettrFunc) 适配为 ContextGettr, ctx 会被忽略。
+func ToContextGettr(gettr Gettr) ContextGettr {
+	return ContextGettrFunc(func(_ context.Context, key string) ([]byte, error) {
+		return gettr.Get(key)
+	})
+}
+
+// TTLGettr 是可选接口, 回调函数在返回数据的同时返回这份数据的过期时间。
+// 传入 NewGroupContext 的 ContextGettr 如果同时实现了 TTLGettr, 载入数据时会优先调用 GetWithTTL。
+// 返回的 ttl <= 0 时使用 Group 的默认过期时间。
+type TTLGettr interface {
+	GetWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// 定义返回过期时间的回调函数，同时实现ContextGettr和TTLGettr接口
+type TTLGettrFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+// 实现ContextGettr接口, 过期时间使用Group的默认值
+func (f TTLGettrFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	b, _, err := f(ctx, key)
+	return b, err
+}
+
+// 实现TTLGettr接口
+func (f TTLGettrFunc) GetWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
 /*
 	函数类型实现某一个接口，称之为接口型函数，方便调用者在调用时既能传入函数作为参数，也能传入实现该接口的结构体作为参数。
 */
@@ -56,32 +101,128 @@ type Group struct {
 	// 名称
 	name string
 	// 缓存未命中时获取源数据的回调函数
-	gettr Gettr
-	// 并发控制缓存
-	coreCache concurrentcache.ConcurrentCache
+	gettr ContextGettr
+	// gettr 同时实现了 TTLGettr 时不为空
+	ttlGettr TTLGettr
+	// gettr 同时实现了 BatchGettr 时不为空
+	batchGettr BatchGettr
+	// 合并并发的未命中, 为空表示不合并
+	batcher *batchLoader
+	// 合并载入的时间窗口和每批的最大数量
+	batchWindow  time.Duration
+	batchMaxSize int
+	// 并发控制缓存, 存放本节点负责的key
+	coreCache *concurrentcache.ConcurrentCache
+	// 热点缓存, 存放从远程节点获取的部分数据, 减轻热点key所在节点的压力。
+	// 为空表示不使用热点缓存
+	hotCache *concurrentcache.ConcurrentCache
+	// 热点缓存的容量
+	hotCacheBytes int64
+	// 从远程节点获取的数据有 1/hotSampleRate 的概率写入热点缓存
+	hotSampleRate int
+	// 最近被淘汰或者过期的数据, 数据源不可用时返回, 为空表示不使用
+	graveyard *concurrentcache.ConcurrentCache
+	// graveyard 的容量和数据最多保留的时间
+	graveyardBytes int64
+	maxStale       time.Duration
+	// 负缓存, 存放数据源中不存在的key, 为空表示不使用
+	negCache *concurrentcache.ConcurrentCache
+	// 负缓存的过期时间和最多保存的key数量
+	negativeTTL  time.Duration
+	negativeKeys int
+	// 缓存的默认过期时间, 0 表示永不过期
+	defaultTTL time.Duration
+	// 过期之后仍然可以返回旧值的时间, 同时在后台刷新, 0 表示不使用
+	staleTTL time.Duration
+	// 有效期过去这个比例之后, 被访问的key会提前刷新, 0 表示不使用
+	refreshAhead float64
+	// 正在后台刷新的key
+	refreshing sync.Map
+	// 创建淘汰策略, 为空时使用LRU
+	newPolicy concurrentcache.NewPolicyFunc
 	// 查找远程节点
 	peerPicker PeerPicker
+	// 等待负责的节点的最长时间
+	peerTimeout time.Duration
+	// 一次载入的最长时间, 与调用方的 ctx 无关
+	loadTimeout time.Duration
+	// 每个key保存的副本数量, 1 表示不复制
+	replicas int
+	// 对冲请求, 为空表示不使用
+	hedge *hedger
 	// 保证每一个key只会被获取一次
 	loader *singleflight.Group
+	// 统计数据
+	Stats Stats
 }
 
+const (
+	// 默认 1/10 的远程数据写入热点缓存
+	defaultHotSampleRate = 10
+	// 热点数据的最长保留时间, 其他节点修改之后最多在这段时间内读到旧值
+	maxHotCacheTTL = time.Minute
+)
+
 var (
 	mu     sync.Mutex
 	groups = make(map[string]*Group)
 )
 
 // 创建Group的一个实例
-func NewGroup(name string, cacheMaxBytes int64, gettr Gettr) *Group {
+func NewGroup(name string, cacheMaxBytes int64, gettr Gettr, opts ...GroupOption) *Group {
+	if gettr == nil {
+		panic("nil Gettr")
+	}
+	if batchGettr, ok := gettr.(BatchGettr); ok {
+		opts = append([]GroupOption{withBatchGettr(batchGettr)}, opts...)
+	}
+	return NewGroupContext(name, cacheMaxBytes, ToContextGettr(gettr), opts...)
+}
+
+// 创建Group的一个实例, 回调函数可以感知调用方的 context
+func NewGroupContext(name string, cacheMaxBytes int64, gettr ContextGettr, opts ...GroupOption) *Group {
 	if gettr == nil {
 		panic("nil Gettr")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:      name,
-		gettr:     gettr,
-		coreCache: concurrentcache.NewConcurrentCache(uint64(cacheMaxBytes)),
-		loader:    &singleflight.Group{},
+		name:          name,
+		gettr:         gettr,
+		loader:        &singleflight.Group{},
+		hotSampleRate: defaultHotSampleRate,
+		batchWindow:   defaultBatchWindow,
+		batchMaxSize:  defaultBatchMaxSize,
+		negativeTTL:   defaultNegativeTTL,
+		negativeKeys:  defaultNegativeKeys,
+		peerTimeout:   defaultPeerTimeout,
+		loadTimeout:   defaultLoadTimeout,
+		replicas:      1,
+	}
+	g.ttlGettr, _ = gettr.(TTLGettr)
+	g.batchGettr, _ = gettr.(BatchGettr)
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.batchGettr != nil {
+		g.batcher = newBatchLoader(g.batchGettr, g.batchWindow, g.batchMaxSize, func(size int) {
+			g.Stats.BatchLoads.Add(1)
+		})
+	}
+	g.coreCache = concurrentcache.NewConcurrentCacheWithPolicy(uint64(cacheMaxBytes), g.newPolicy)
+	if g.hotCacheBytes > 0 {
+		g.hotCache = concurrentcache.NewConcurrentCacheWithPolicy(uint64(g.hotCacheBytes), g.newPolicy)
+	}
+	if g.graveyardBytes > 0 {
+		g.graveyard = concurrentcache.NewConcurrentCache(uint64(g.graveyardBytes))
+		g.coreCache.OnEvicted = g.bury
+	}
+	if g.negativeTTL > 0 && g.negativeKeys > 0 {
+		g.negCache = concurrentcache.NewConcurrentCache(uint64(g.negativeKeys) * negativeMarker.Len())
+	}
+	// 替换同名的Group时释放旧的
+	if old, ok := groups[name]; ok {
+		old.close()
 	}
 	groups[name] = g
 	return g
@@ -96,78 +237,317 @@ func GetGroup(name string) (*Group, bool) {
 	return nil, false
 }
 
+// Close 停止各个缓存的后台清理, 并从全局注册表中删除。
+// 之后仍然可以读写, 但是过期的数据只在被访问时删除。
+func (g *Group) Close() {
+	mu.Lock()
+	if groups[g.name] == g {
+		delete(groups, g.name)
+	}
+	mu.Unlock()
+	g.close()
+}
+
+func (g *Group) close() {
+	for _, c := range []*concurrentcache.ConcurrentCache{g.coreCache, g.hotCache, g.graveyard, g.negCache} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
 // 从指定Group的缓存中读取key值。
 func (g *Group) Get(key string) (view.ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// 从指定Group的缓存中读取key值, ctx 会一直传递到回调函数和远程节点的请求中。
+// ctx 被取消或超时后, 调用方立即返回 ctx.Err()。
+func (g *Group) GetContext(ctx context.Context, key string) (view.ByteView, error) {
 	if key == "" {
 		return view.ByteView{}, errors.New("key is required")
 	}
 
+	g.Stats.Gets.Add(1)
 	// (1)命中本地缓存
-	if v, ok := g.coreCache.Get(key); ok {
-		log.Println("Cache Hit!")
+	if v, ok := g.lookupCache(key); ok {
 		return v, nil
 	}
+	// 最近确认过数据源中不存在
+	if g.lookupNegative(key) {
+		return view.ByteView{}, notFound(key)
+	}
 
 	// 获取k-v，(2)(3)
-	return g.load(key)
-}
-
-func (g *Group) load(key string) (view.ByteView, error) {
-	// 封装
-	viewI, err := g.loader.Do(key, func() (interface{}, error) {
-		// 注册了集群节点
-		if g.peerPicker != nil {
-			var err error
-			// key匹配到了集群节点
-			if peer, ok := g.peerPicker.PickPeer(key); ok {
-				// 从匹配的节点中获取了信息
-				if value, err := g.getFromCluster(peer, key); err == nil {
-					return value, nil
-				}
-				// 从集群获取失败
-				log.Println("[GeeCache] Failed to get from peer", err)
-			}
+	return g.load(ctx, key)
+}
+
+// 依次查找本地缓存和热点缓存
+func (g *Group) lookupCache(key string) (view.ByteView, bool) {
+	if item, ok := g.coreCache.GetItem(key); ok {
+		g.Stats.CacheHits.Add(1)
+		// 需要刷新时先返回旧值, 在后台刷新
+		if g.needsRefresh(item, time.Now()) {
+			g.refresh(key)
+		}
+		return item.Value, true
+	}
+	// 命中热点缓存, 不需要访问远程节点
+	if g.hotCache != nil {
+		if v, ok := g.hotCache.Get(key); ok {
+			g.Stats.CacheHits.Add(1)
+			g.Stats.HotCacheHits.Add(1)
+			return v, true
 		}
-		return g.getFromLocalDB(key)
+	}
+	g.Stats.CacheMisses.Add(1)
+	return view.ByteView{}, false
+}
+
+func (g *Group) load(ctx context.Context, key string) (view.ByteView, error) {
+	g.Stats.Loads.Add(1)
+	return g.loadShared(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return g.fetch(ctx, key)
 	})
+}
 
-	if err == nil {
-		return viewI.(view.ByteView), nil
+// 通过 singleflight 载入, 同一个key的所有调用方共享一次载入。
+// 载入使用独立的 ctx, 最多执行 loadTimeout, 不会因为某一个调用方取消而失败; 所有调用方都取消之后载入也被取消
+func (g *Group) loadShared(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (view.ByteView, error) {
+	viewI, err := g.loader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, g.loadTimeout)
+		defer cancel()
+		return fn(ctx)
+	})
+	if err != nil {
+		return view.ByteView{}, err
 	}
+	return viewI.(view.ByteView), nil
+}
 
-	return view.ByteView{}, err
+// 从远程节点或者数据源获取, 由 singleflight 保证同一个key同时只有一个
+func (g *Group) fetch(ctx context.Context, key string) (interface{}, error) {
+	g.Stats.LoadsDeduped.Add(1)
+	owners := g.pickOwners(key)
+	if g.hedge != nil && len(owners) > 0 {
+		return g.fetchHedged(ctx, key, owners)
+	}
+	// 依次尝试负责key的节点, 开启副本时为所有副本
+	for _, peer := range owners {
+		// 从匹配的节点中获取了信息, 每个节点最多等待 peerTimeout
+		peerCtx, cancel := context.WithTimeout(ctx, g.peerTimeout)
+		value, err := g.getFromCluster(peerCtx, peer, key)
+		cancel()
+		if done, err := g.peerResult(ctx, key, value, err); done {
+			if err != nil {
+				return nil, err
+			}
+			return value, nil
+		}
+	}
+	return g.getFromLocalDB(ctx, key)
+}
+
+// 处理从一个节点获取的结果, fetch 和 fetchHedged 共用。
+// 返回true时载入结束, 返回 err, 为nil时 value 有效; 返回false时节点没有响应, 尝试下一个副本, 最后回退到本地
+func (g *Group) peerResult(ctx context.Context, key string, value view.ByteView, err error) (bool, error) {
+	if err == nil {
+		g.Stats.PeerLoads.Add(1)
+		g.populateHotCache(key, value)
+		return true, nil
+	}
+	// 负责的节点确认不存在, 不需要回退到本地
+	if errors.Is(err, ErrNotFound) {
+		return true, err
+	}
+	// 从集群获取失败
+	g.Stats.PeerErrors.Add(1)
+	// 负责的节点已经访问过数据源, 回退到本地只会重复载入
+	if errors.Is(err, errOwnerLoad) {
+		return true, err
+	}
+	// 载入已经超时, 不再回退到本地
+	if ctx.Err() != nil {
+		return true, ctx.Err()
+	}
+	log.Println("[GeeCache] Failed to get from peer", err)
+	return false, err
 }
 
 // (2) 集群中获取数据
-func (g *Group) getFromCluster(peer PeerServer, key string) (view.ByteView, error) {
+func (g *Group) getFromCluster(ctx context.Context, peer PeerServer, key string) (view.ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	err := peer.Get(ctx, req, res)
 	if err != nil {
 		return view.ByteView{}, err
 	}
-	return view.ByteView{B: res.GetValue()}, nil
+	return view.ByteView{B: res.GetValue(), Stale: res.GetStale()}, nil
 }
 
 // （3）数据源（数据库）获取缓存添加到缓存中。
-func (g *Group) getFromLocalDB(key string) (view.ByteView, error) {
+func (g *Group) getFromLocalDB(ctx context.Context, key string) (view.ByteView, error) {
 	// 调用回调函数，获取本地数据库中的k-v值。
-	byteSlice, err := g.gettr.Get(key)
+	var (
+		byteSlice []byte
+		ttl       time.Duration
+		err       error
+	)
+	switch {
+	case g.batcher != nil:
+		// 与其他并发的未命中合并载入, 过期时间使用默认值
+		byteSlice, err = g.batcher.load(ctx, key)
+	case g.ttlGettr != nil:
+		byteSlice, ttl, err = g.ttlGettr.GetWithTTL(ctx, key)
+	default:
+		byteSlice, err = g.gettr.Get(ctx, key)
+	}
 	if err != nil {
+		g.Stats.LocalLoadErrs.Add(1)
+		if errors.Is(err, ErrNotFound) {
+			g.populateNegative(key)
+			return view.ByteView{}, err
+		}
+		// 数据源不可用时返回最近被淘汰或者过期的旧值
+		if v, ok := g.lookupGraveyard(key); ok {
+			log.Println("[GeeCache] Serving stale value of", key, "after error:", err)
+			return v, nil
+		}
 		return view.ByteView{}, err
 	}
+	g.Stats.LocalLoads.Add(1)
 
 	v := view.ByteView{B: byteSlice}
-	g.populateCache(key, v)
+	g.populateCache(key, v, ttl)
+	g.fillReplicas(key, v)
 	return v, nil
 }
 
-// 添加k-v
-func (g *Group) populateCache(key string, value view.ByteView) {
-	g.coreCache.Add(key, value)
+// 添加k-v, ttl <= 0 时使用默认过期时间
+func (g *Group) populateCache(key string, value view.ByteView, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = g.defaultTTL
+	}
+	if ttl > 0 && g.staleTTL > 0 {
+		// ttl 之后需要刷新, 再过 staleTTL 才真正过期
+		g.coreCache.AddWithRefresh(key, value, ttl, ttl+g.staleTTL)
+		return
+	}
+	g.coreCache.AddWithTTL(key, value, ttl)
+}
+
+// 按照采样比例将远程节点的数据写入热点缓存, 热点key被多次获取后大概率留在本地
+func (g *Group) populateHotCache(key string, value view.ByteView) {
+	// 旧值不写入热点缓存
+	if g.hotCache == nil || value.Stale || rand.Intn(g.hotSampleRate) != 0 {
+		return
+	}
+	ttl := maxHotCacheTTL
+	if g.defaultTTL > 0 && g.defaultTTL < ttl {
+		ttl = g.defaultTTL
+	}
+	g.hotCache.AddWithTTL(key, value, ttl)
+}
+
+// Set 主动写入一个key, 例如数据库更新之后。
+// 负责该key的节点是远程节点时写入远程节点, 同时清除本地的副本。开启副本时写入所有副本。
+// 负责的节点熔断时仍然发送, 失败时返回错误。
+func (g *Group) Set(key string, value []byte) error {
+	return g.SetContext(context.Background(), key, value)
+}
+
+// 与 Set 相同, ctx 会传递到远程节点的请求中。
+func (g *Group) SetContext(ctx context.Context, key string, value []byte) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	if replicas, ok := g.pickWriteReplicas(key); ok {
+		return g.setReplicas(ctx, replicas, key, value)
+	}
+	if peer, ok := g.pickWriteOwner(key); ok {
+		defer g.removeLocally(key)
+		req := &pb.SetRequest{
+			Group: g.name,
+			Key:   key,
+			Value: value,
+		}
+		return peer.Set(ctx, req, &pb.Ack{})
+	}
+	g.setLocally(key, value)
+	return nil
+}
+
+// Remove 删除一个key, 使缓存失效。
+// 负责该key的节点是远程节点时从远程节点删除, 同时清除本地的副本。开启副本时从所有副本删除。
+// 负责的节点熔断时仍然发送, 失败时返回错误。
+func (g *Group) Remove(key string) error {
+	return g.RemoveContext(context.Background(), key)
+}
+
+// 与 Remove 相同, ctx 会传递到远程节点的请求中。
+func (g *Group) RemoveContext(ctx context.Context, key string) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	defer g.removeLocally(key)
+	if replicas, ok := g.pickWriteReplicas(key); ok {
+		return g.removeReplicas(ctx, replicas, key)
+	}
+	if peer, ok := g.pickWriteOwner(key); ok {
+		req := &pb.Request{
+			Group: g.name,
+			Key:   key,
+		}
+		return peer.Remove(ctx, req, &pb.Ack{})
+	}
+	return nil
+}
+
+// 查找负责key的远程节点, 没有注册集群或者key属于本节点时返回false
+func (g *Group) pickPeer(key string) (PeerServer, bool) {
+	if g.peerPicker == nil {
+		return nil, false
+	}
+	return g.peerPicker.PickPeer(key)
+}
+
+// 查找写入和删除时负责key的远程节点, 与 pickPeer 不同, 不会选择负责的节点之外的节点
+func (g *Group) pickWriteOwner(key string) (PeerServer, bool) {
+	if picker, ok := g.peerPicker.(OwnerPicker); ok {
+		return picker.PickOwner(key)
+	}
+	return g.pickPeer(key)
+}
+
+// 写入本地缓存, 不会转发给其他节点
+func (g *Group) setLocally(key string, value []byte) {
+	// 拷贝一份, 防止调用方修改
+	b := make([]byte, len(value))
+	copy(b, value)
+	g.populateCache(key, view.ByteView{B: b}, 0)
+	if g.negCache != nil {
+		g.negCache.Remove(key)
+	}
+	if g.graveyard != nil {
+		g.graveyard.Remove(key)
+	}
+}
+
+// 删除本地缓存, 热点缓存, 负缓存和旧值, 不会转发给其他节点
+func (g *Group) removeLocally(key string) {
+	g.coreCache.Remove(key)
+	if g.hotCache != nil {
+		g.hotCache.Remove(key)
+	}
+	if g.negCache != nil {
+		g.negCache.Remove(key)
+	}
+	if g.graveyard != nil {
+		g.graveyard.Remove(key)
+	}
 }
 
 // HTTPServer 实现了 PeerPicker，传递进来。
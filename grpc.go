@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	consistenthash "mini-cache/consistent-hash"
+	"mini-cache/metrics"
+	pb "mini-cache/proto"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 提供被其他节点访问的能力（基于gRPC）
+// 与 HttpServer 相比, 节点之间使用多路复用的长连接, 请求的超时时间会传递给对方节点。
+
+// gRPC Server Pool
+type GrpcServer struct {
+	// 本节点的地址, 例如 "10.0.0.2:8008"
+	selfAddr string
+	// 互斥锁
+	mu sync.Mutex
+	// 一致性Hash算法控制类
+	consistentHashPool *consistenthash.Pool
+	// 映射远程节点的gRPC client。keyed by e.g. "10.0.0.2:8008"
+	grpcClient map[string]*grpcClient
+	// 连接远程节点时使用的参数
+	dialOpts []grpc.DialOption
+	// 处理其他节点的请求
+	server *grpc.Server
+}
+
+// 初始化节点的gRPC Pool, 默认不使用TLS
+func NewGrpcServer(selfAddr string, dialOpts ...grpc.DialOption) *GrpcServer {
+	s := &GrpcServer{
+		selfAddr:           selfAddr,
+		consistentHashPool: consistenthash.New(defaultReplicas, nil),
+		grpcClient:         make(map[string]*grpcClient),
+		dialOpts:           append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, dialOpts...),
+		server:             grpc.NewServer(),
+	}
+	pb.RegisterGroupCacheServer(s.server, &grpcHandler{})
+	return s
+}
+
+// 日志
+func (s *GrpcServer) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s", s.selfAddr, fmt.Sprintf(format, v...))
+}
+
+// Serve 在lis上处理其他节点的请求, 直到 Stop 被调用
+func (s *GrpcServer) Serve(lis net.Listener) error {
+	return s.server.Serve(lis)
+}
+
+// Stop 停止处理请求, 并关闭到其他节点的连接
+func (s *GrpcServer) Stop() {
+	s.server.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.grpcClient {
+		c.conn.Close()
+	}
+}
+
+// 添加新节点，需要更新映射
+func (s *GrpcServer) Set(peersAddr ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(peersAddr...)
+}
+
+// SetPeers 用peersAddr替换集群中的所有节点, 离开的节点的连接会被关闭
+func (s *GrpcServer) SetPeers(peersAddr ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := make(map[string]bool, len(peersAddr))
+	for _, peerAddr := range peersAddr {
+		keep[peerAddr] = true
+	}
+	for _, peerAddr := range s.consistentHashPool.Peers() {
+		if keep[peerAddr] {
+			continue
+		}
+		s.consistentHashPool.Remove(peerAddr)
+		if c, ok := s.grpcClient[peerAddr]; ok {
+			c.conn.Close()
+			delete(s.grpcClient, peerAddr)
+		}
+	}
+	s.add(peersAddr...)
+}
+
+func (s *GrpcServer) add(peersAddr ...string) {
+	s.consistentHashPool.Add(peersAddr...)
+	// 为每一个远程节点都建立一个连接, 连接是惰性建立的, 这里不会阻塞
+	for _, peerAddr := range peersAddr {
+		if _, ok := s.grpcClient[peerAddr]; ok || peerAddr == s.selfAddr {
+			continue
+		}
+		conn, err := grpc.Dial(peerAddr, s.dialOpts...)
+		if err != nil {
+			s.Log("dial %s failed: %v", peerAddr, err)
+			continue
+		}
+		s.grpcClient[peerAddr] = &grpcClient{
+			peer:    peerAddr,
+			conn:    conn,
+			client:  pb.NewGroupCacheClient(conn),
+			latency: metrics.NewHistogram(nil),
+		}
+	}
+}
+
+// WriteMetrics 以Prometheus文本格式输出访问每个远程节点的延迟, Group的指标由 cache.WriteMetrics 输出
+func (s *GrpcServer) WriteMetrics(w io.Writer) {
+	s.mu.Lock()
+	latency := make(map[string]*metrics.Histogram, len(s.grpcClient))
+	for peer, c := range s.grpcClient {
+		latency[peer] = c.latency
+	}
+	s.mu.Unlock()
+	writePeerLatency(w, latency)
+}
+
+// PickerPeer() 包装了一致性哈希算法的 Get() 方法，根据具体的 key，选择节点，返回节点对应的 gRPC 客户端。
+func (s *GrpcServer) PickPeer(key string) (PeerServer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if peerAddr := s.consistentHashPool.Get(key); peerAddr != "" && peerAddr != s.selfAddr {
+		if c, ok := s.grpcClient[peerAddr]; ok {
+			s.Log("Pick Peer %s", peerAddr)
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// PickReplicas 返回key的最多n个副本所在的节点, 本节点为nil, 无法连接的节点会被跳过
+func (s *GrpcServer) PickReplicas(key string, n int) []PeerServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var peers []PeerServer
+	for _, peerAddr := range s.consistentHashPool.GetN(key, n) {
+		if peerAddr == s.selfAddr {
+			peers = append(peers, nil)
+		} else if c, ok := s.grpcClient[peerAddr]; ok {
+			peers = append(peers, c)
+		}
+	}
+	return peers
+}
+
+// 实现 pb.GroupCacheServer, 处理其他节点的请求
+type grpcHandler struct {
+	pb.UnimplementedGroupCacheServer
+}
+
+func (h *grpcHandler) group(name string) (*Group, error) {
+	group, ok := GetGroup(name)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "no such group: %s", name)
+	}
+	return group, nil
+}
+
+func (h *grpcHandler) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	group, err := h.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.Stats.ServerRequests.Add(1)
+	// 请求方的超时时间通过 ctx 传递过来, 只控制等待的时间
+	view, err := group.getForPeer(ctx, in.GetKey())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		// 数据源载入失败, 请求方不会回退到本地
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	return &pb.Response{Value: view.ByteSlice(), Stale: view.Stale}, nil
+}
+
+func (h *grpcHandler) Set(ctx context.Context, in *pb.SetRequest) (*pb.Ack, error) {
+	group, err := h.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.setLocally(in.GetKey(), in.GetValue())
+	return &pb.Ack{}, nil
+}
+
+func (h *grpcHandler) Remove(ctx context.Context, in *pb.Request) (*pb.Ack, error) {
+	group, err := h.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.removeLocally(in.GetKey())
+	return &pb.Ack{}, nil
+}
+
+func (h *grpcHandler) GetMany(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	group, err := h.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.Stats.ServerRequests.Add(1)
+	return group.batchResponse(ctx, in.GetKeys()), nil
+}
+
+// gRPC客户端类
+type grpcClient struct {
+	// 节点地址, 例如 "10.0.0.2:8008"
+	peer   string
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+	// 请求的耗时, 节点离开时一起删除
+	latency *metrics.Histogram
+}
+
+// 记录一次请求的耗时
+func (c *grpcClient) observe(start time.Time) {
+	c.latency.Observe(time.Since(start))
+}
+
+// 实现PeerServer接口, ctx 的超时时间会传递给对方节点
+func (c *grpcClient) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	defer c.observe(time.Now())
+	res, err := c.client.Get(ctx, in)
+	switch status.Code(err) {
+	case codes.NotFound:
+		return notFound(in.GetKey())
+	case codes.Unknown:
+		return ownerLoadError(status.Convert(err).Message())
+	}
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+func (c *grpcClient) Set(ctx context.Context, in *pb.SetRequest, out *pb.Ack) error {
+	defer c.observe(time.Now())
+	res, err := c.client.Set(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+func (c *grpcClient) Remove(ctx context.Context, in *pb.Request, out *pb.Ack) error {
+	defer c.observe(time.Now())
+	res, err := c.client.Remove(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+func (c *grpcClient) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	defer c.observe(time.Now())
+	res, err := c.client.GetMany(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
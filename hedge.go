@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"mini-cache/view"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 对冲请求: 负责的节点偶尔响应很慢时, 同时向下一个副本(或者本地数据源)发送请求, 使用先返回的结果
+
+/*
+	负责的节点 ----请求----------------------------> 先返回的结果, 取消另一个请求
+	                |-- 超过延迟的 percentile --> 下一个副本 / 本地载入
+	延迟根据最近成功的请求计算, 对冲请求的数量不超过请求数量的 budget 比例。
+	每次载入最多发出一个对冲请求。
+*/
+
+const (
+	// 计算延迟分位数使用的最近请求数量
+	hedgeSamples = 128
+	// 对冲请求最多累积的额度, 防止空闲之后短时间内发出大量对冲请求
+	hedgeMaxTokens = 10
+)
+
+type hedger struct {
+	// 使用最近请求延迟的这个分位数作为等待时间, 不小于 minDelay
+	percentile float64
+	minDelay   time.Duration
+	// 每个请求增加的对冲额度
+	budget float64
+
+	mu sync.Mutex
+	// 最近成功的请求的延迟, 环形缓冲区
+	samples []time.Duration
+	next    int
+	// 可以发出的对冲请求数量
+	tokens float64
+}
+
+func newHedger(percentile float64, minDelay time.Duration, budget float64) *hedger {
+	return &hedger{
+		percentile: percentile,
+		minDelay:   minDelay,
+		budget:     budget,
+		samples:    make([]time.Duration, 0, hedgeSamples),
+	}
+}
+
+// 记录一次成功的请求的延迟
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % hedgeSamples
+}
+
+// 发出对冲请求之前等待的时间
+func (h *hedger) delay() time.Duration {
+	h.mu.Lock()
+	sorted := append([]time.Duration(nil), h.samples...)
+	h.mu.Unlock()
+	if len(sorted) == 0 {
+		return h.minDelay
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	d := sorted[int(h.percentile*float64(len(sorted)-1))]
+	if d < h.minDelay {
+		return h.minDelay
+	}
+	return d
+}
+
+// 每个请求增加 budget 的额度
+func (h *hedger) request() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens += h.budget
+	if h.tokens > hedgeMaxTokens {
+		h.tokens = hedgeMaxTokens
+	}
+}
+
+// 是否还有额度发出对冲请求
+func (h *hedger) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+type hedgeResult struct {
+	value view.ByteView
+	err   error
+	// 本地载入的结果
+	local bool
+	// 对冲请求的结果
+	hedge bool
+}
+
+// 与 fetch 相同, 依次尝试 owners 中的节点, 最后本地载入; 当前的请求太慢时提前开始下一个
+func (g *Group) fetchHedged(ctx context.Context, key string, owners []PeerServer) (interface{}, error) {
+	// 返回时取消还没有结束的请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g.hedge.request()
+
+	results := make(chan hedgeResult, len(owners)+1)
+	// next 为下一个要尝试的节点的下标, 等于 len(owners) 时表示本地载入
+	next, running := 0, 0
+	// 本地载入失败的错误, 还有正在进行的请求时先不返回
+	var localErr error
+	// 对冲的本地载入正在进行时, 远程节点返回的最终错误等本地载入结束之后再返回
+	var finalErr error
+	localRunning := false
+	start := func(hedge bool) {
+		if next == len(owners) {
+			localRunning = true
+			go func() {
+				v, err := g.getFromLocalDB(ctx, key)
+				results <- hedgeResult{value: v, err: err, local: true, hedge: hedge}
+			}()
+		} else {
+			peer := owners[next]
+			go func() {
+				begin := time.Now()
+				peerCtx, cancel := context.WithTimeout(ctx, g.peerTimeout)
+				defer cancel()
+				v, err := g.getFromCluster(peerCtx, peer, key)
+				if err == nil {
+					g.hedge.observe(time.Since(begin))
+				}
+				results <- hedgeResult{value: v, err: err, hedge: hedge}
+			}()
+		}
+		next++
+		running++
+	}
+
+	start(false)
+	timer := time.NewTimer(g.hedge.delay())
+	defer timer.Stop()
+	hedgeC := timer.C
+	for {
+		select {
+		case <-hedgeC:
+			hedgeC = nil
+			if next <= len(owners) && g.hedge.allow() {
+				g.Stats.Hedges.Add(1)
+				start(true)
+			}
+		case r := <-results:
+			running--
+			if r.local {
+				localRunning = false
+				if r.err == nil {
+					if r.hedge {
+						g.Stats.HedgeWins.Add(1)
+					}
+					return r.value, nil
+				}
+				if finalErr != nil {
+					return nil, finalErr
+				}
+				// 对冲的本地载入失败, 继续等待较慢但是可能成功的远程请求
+				if running > 0 {
+					localErr = r.err
+					continue
+				}
+				return nil, r.err
+			}
+			done, err := g.peerResult(ctx, key, r.value, r.err)
+			if done && err == nil {
+				if r.hedge {
+					g.Stats.HedgeWins.Add(1)
+				}
+				return r.value, nil
+			}
+			if done {
+				// 本地载入已经在访问数据源, 等待它的结果, 不会在返回之后继续执行
+				if localRunning {
+					finalErr = err
+					continue
+				}
+				return nil, err
+			}
+			// 没有正在进行的请求时尝试下一个, 本地载入也已经失败时返回它的错误
+			if running == 0 {
+				if localErr != nil {
+					return nil, localErr
+				}
+				start(false)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"mini-cache/consistent-hash"
+	"mini-cache/metrics"
 	pb "mini-cache/proto"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/protobuf/proto"
@@ -21,10 +26,22 @@ import (
 const (
 	defaultBasePath      = "/api/cache/"
 	defaultReplicas      = 50
+	// 默认最多同时处理的请求数量和排队的最长时间
 	defaultConnectNumber = 5000
 	defaultTimeout       = 5 * time.Second
+	// 默认的请求体的最大长度
+	defaultMaxRequestBytes = 64 << 20
 )
 
+// 响应中带有这个header表示数据源中不存在请求的key
+const notFoundHeader = "X-Cache-Not-Found"
+
+// 节点已经离开集群
+var errPeerClosed = errors.New("peer removed from the cluster")
+
+// 请求体超过了 maxRequestBytes
+var errRequestTooLarge = errors.New("request body too large")
+
 // HTTP Server Pool
 type HttpServer struct {
 	// 记录URL地址，主机名、IP、端口号。
@@ -33,24 +50,89 @@ type HttpServer struct {
 	basePath string
 	// 互斥锁
 	mu sync.Mutex
-	// 一致性Hash算法控制类
-	consistentHashPool *consistenthash.Pool
+	// 选择节点的算法, 默认为一致性Hash
+	picker consistenthash.Picker
 	// 映射远程节点的的http client。keyed by e.g. "http://10.0.0.2:8008"
 	httpClient map[string]*httpClient
-	// 控制HTTP连接数量
-	ch chan interface{}
+	// 控制同时处理的请求数量
+	admission *admission
+	// 组的优先级, 没有设置的组为0
+	priorities map[string]int
+	// 有界负载的 epsilon, 为0时不开启; 开启后统计每个节点正在处理的请求
+	loadBound float64
+	// picker 支持有界负载并且开启时不为nil
+	bounded consistenthash.BoundedPicker
+	// 访问其他节点的HTTP客户端的配置
+	transport transportConfig
+	// 每个远程节点的熔断器的配置
+	breaker breakerConfig
+	// 其他节点的请求体的最大长度
+	maxRequestBytes int64
+}
+
+// HttpServerOption 修改 HttpServer 的配置
+type HttpServerOption func(*HttpServer)
+
+// WithBoundedLoad 开启有界负载的一致性哈希, 每个节点的负载不超过平均负载的 (1+epsilon) 倍,
+// 超过时请求溢出到哈希环上的下一个节点。负载为本节点发往每个节点的、还没有完成的请求数量,
+// 本节点的负载为正在处理的其他节点的请求数量。
+// 只有支持有界负载的 Picker (consistenthash.Pool) 才会生效。
+func WithBoundedLoad(epsilon float64) HttpServerOption {
+	return func(p *HttpServer) {
+		p.loadBound = epsilon
+	}
+}
+
+// WithPicker 设置选择节点的算法, 例如 consistenthash.NewRendezvous(nil)。
+// 默认为 50 个虚拟节点的一致性Hash环。picker 中已经添加的节点与 Set 添加的节点相同。
+func WithPicker(picker consistenthash.Picker) HttpServerOption {
+	return func(p *HttpServer) {
+		if picker != nil {
+			p.picker = picker
+		}
+	}
+}
+
+// WithMaxRequestBytes 设置其他节点的请求体 (写入的value和批量请求) 的最大长度, 超过时返回413。默认为64MB
+func WithMaxRequestBytes(n int64) HttpServerOption {
+	return func(p *HttpServer) {
+		if n > 0 {
+			p.maxRequestBytes = n
+		}
+	}
 }
 
 // 初始化节点的HTTPPool
-func NewHttpServer(selfPath string) *HttpServer {
-	return &HttpServer{
-		selfPath:           selfPath,
-		basePath:           defaultBasePath,
-		mu:                 sync.Mutex{},
-		consistentHashPool: consistenthash.New(defaultReplicas, nil),
-		httpClient:         make(map[string]*httpClient),
-		ch:                 make(chan interface{}, defaultConnectNumber),
+func NewHttpServer(selfPath string, opts ...HttpServerOption) *HttpServer {
+	p := &HttpServer{
+		selfPath:   selfPath,
+		basePath:   defaultBasePath,
+		mu:         sync.Mutex{},
+		picker:     consistenthash.New(defaultReplicas, nil),
+		httpClient: make(map[string]*httpClient),
+		admission:  newAdmission(defaultConnectNumber, defaultQueueLength, defaultTimeout),
+		priorities: make(map[string]int),
+		transport:  defaultTransportConfig(),
+		breaker:    defaultBreakerConfig(),
+
+		maxRequestBytes: defaultMaxRequestBytes,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	if p.loadBound > 0 {
+		if bounded, ok := p.picker.(consistenthash.BoundedPicker); ok {
+			bounded.SetLoadBound(p.loadBound)
+			p.bounded = bounded
+		} else {
+			p.Log("picker %T does not support bounded loads", p.picker)
+		}
+	}
+	// picker 中可能已经有节点, 为它们创建客户端
+	for _, peerPath := range p.picker.Peers() {
+		p.addClient(peerPath)
+	}
+	return p
 }
 
 // 日志
@@ -60,53 +142,128 @@ func (p *HttpServer) Log(format string, v ...interface{}) {
 
 // ServeHttp处理所有的请求
 func (p *HttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// 控制并发数量
-	select {
-	case p.ch <- struct{}{}:
-		// 可以执行HTTP请求
-	case <-time.After(defaultTimeout):
-		// 超时
-		w.Header().Set("Content-Type", "application/octet-stream")
-		http.Error(w, errors.New("timeout").Error(), http.StatusInternalServerError)
+	// 指标和管理接口不受并发数量的限制, 过载时仍然可以访问
+	switch r.URL.Path {
+	case defaultMetricsPath:
+		w.Header().Set("Content-Type", metrics.ContentType)
+		WriteMetrics(w)
+		p.writeLatencyMetrics(w)
+		p.writeBreakerMetrics(w)
+		return
+	case defaultBreakerPath:
+		p.serveBreakers(w, r)
 		return
 	}
-
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		log.Println("HTTPPool serving unexpected path: " + r.URL.Path)
 		return
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
 	// /<basepath>/<groupname>/<key> required
+	// 批量请求为 POST /<basepath>/<groupname>, 请求体为 pb.BatchRequest
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
-	if len(parts) != 2 {
+	if len(parts) != 2 && r.Method != http.MethodPost {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	groupName := parts[0]
-	key := parts[1]
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	if key == "" && r.Method != http.MethodPost {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	// 控制并发数量, 所有返回路径都会释放
+	if err := p.admission.acquire(r.Context(), p.priorities[groupName]); err != nil {
+		p.Log("reject %s %s: %v", r.Method, r.URL.Path, err)
+		if group, ok := GetGroup(groupName); ok {
+			group.Stats.ServerRejected.Add(1)
+		}
+		rejectRequest(w, err)
+		return
+	}
+	defer p.admission.release()
 
 	group, ok := GetGroup(groupName)
 	if !ok {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
+	group.Stats.ServerRequests.Add(1)
+	// 本节点正在处理的请求也计入负载
+	p.track(p.selfPath, 1)
+	defer p.track(p.selfPath, -1)
 
-	view, err := group.Get(key)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	switch r.Method {
+	case http.MethodPost:
+		body, err := p.readBody(w, r)
+		if err != nil {
+			return
+		}
+		req := &pb.BatchRequest{}
+		if err = proto.Unmarshal(body, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err = proto.Marshal(group.batchResponse(r.Context(), req.GetKeys()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(body)
+	case http.MethodPut:
+		// 写入本地缓存, 请求体为value
+		value, err := p.readBody(w, r)
+		if err != nil {
+			return
+		}
+		group.setLocally(key, value)
+	case http.MethodDelete:
+		// 删除本地缓存
+		group.removeLocally(key)
+	default:
+		// 请求方断开连接或超时后, r.Context() 会被取消, 但载入会继续
+		view, err := group.getForPeer(r.Context(), key)
+		if errors.Is(err, ErrNotFound) {
+			// 与 "no such group" 的404区分
+			w.Header().Set(notFoundHeader, "1")
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			// 数据源载入失败, 请求方不会回退到本地
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice(), Stale: view.Stale})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(body)
 	}
+}
 
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+// 读取请求体, 最多 maxRequestBytes; 失败时已经写入了错误响应
+func (p *HttpServer) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, p.maxRequestBytes+1))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
 	}
-
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(body)
-	<-p.ch // 完成, 释放占用的HTTP连接数量
+	if int64(len(b)) > p.maxRequestBytes {
+		http.Error(w, errRequestTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return nil, errRequestTooLarge
+	}
+	return b, nil
 }
 
 // 添加新节点，需要更新映射
@@ -114,62 +271,339 @@ func (p *HttpServer) Set(peersPath ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	// 一致性Hash算法初始化, 这里使用延迟初始化。
-	// if p.consistentHashPool == nil {
-	// 	p.consistentHashPool = consistenthash.New(defaultReplicas, nil)
+	// if p.picker == nil {
+	// 	p.picker = consistenthash.New(defaultReplicas, nil)
 	// }
-	p.consistentHashPool.Add(peersPath...)
+	p.picker.Add(peersPath...)
 	// 为每一个节点都初始化一个Http客户端
 	// p.httpClient = make(map[string]*httpClient, len(peersPath))
 	for _, peerPath := range peersPath {
-		p.httpClient[peerPath] = &httpClient{baseURL: peerPath + p.basePath}
+		p.addClient(peerPath)
+	}
+}
+
+// 为节点创建Http客户端, 已经存在时忽略
+func (p *HttpServer) addClient(peerPath string) {
+	if _, ok := p.httpClient[peerPath]; ok {
+		return
+	}
+	client := newHttpClient(peerPath, peerPath+p.basePath, p.transport)
+	if p.bounded != nil {
+		client.track = p.track
+	}
+	if p.breaker.errorRate > 0 && peerPath != p.selfPath {
+		client.breaker = newBreaker(p.breaker)
+	}
+	p.httpClient[peerPath] = client
+}
+
+// 输出访问每个远程节点的延迟, 只包括仍在集群中的节点
+func (p *HttpServer) writeLatencyMetrics(w io.Writer) {
+	p.mu.Lock()
+	latency := make(map[string]*metrics.Histogram, len(p.httpClient))
+	for peer, client := range p.httpClient {
+		if peer != p.selfPath {
+			latency[peer] = client.latency
+		}
+	}
+	p.mu.Unlock()
+	writePeerLatency(w, latency)
+}
+
+// 节点开始(delta > 0)或者完成(delta < 0)一个请求
+func (p *HttpServer) track(peer string, delta int) {
+	if p.bounded == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if delta > 0 {
+		p.bounded.Inc(peer)
+	} else {
+		p.bounded.Done(peer)
+	}
+}
+
+// SetPeers 用peersPath替换集群中的所有节点, 例如节点故障或者扩容之后。
+// 替换是原子的, PickPeer 只会看到替换之前或者之后的节点; 离开的节点的客户端会被关闭。
+func (p *HttpServer) SetPeers(peersPath ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keep := make(map[string]bool, len(peersPath))
+	for _, peerPath := range peersPath {
+		keep[peerPath] = true
+	}
+	for peerPath, client := range p.httpClient {
+		if !keep[peerPath] {
+			p.picker.Remove(peerPath)
+			client.close()
+			delete(p.httpClient, peerPath)
+		}
+	}
+	p.picker.Add(peersPath...)
+	for _, peerPath := range peersPath {
+		p.addClient(peerPath)
+	}
+}
+
+// Peers 返回集群中的所有节点
+func (p *HttpServer) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.picker.Peers()
+}
+
+// Loads 返回每个节点当前的负载, 没有开启有界负载时返回nil
+func (p *HttpServer) Loads() map[string]int64 {
+	if p.bounded == nil {
+		return nil
 	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bounded.Loads()
 }
 
-// PickerPeer() 包装了一致性哈希算法的 Get() 方法，根据具体的 key，选择节点，返回节点对应的 HTTP 客户端。
+// PickerPeer() 包装了 Picker 的 Get() 方法，根据具体的 key，选择节点，返回节点对应的 HTTP 客户端。
+// 开启有界负载时跳过已经达到负载上限的节点; 节点熔断时返回false, 由调用方回退到本地。
 func (p *HttpServer) PickPeer(key string) (PeerServer, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// 根据虚拟节点的key来查找真实节点的位置。
-	if peerPath := p.consistentHashPool.Get(key); peerPath != "" && peerPath != p.selfPath {
+	var peerPath string
+	if p.bounded != nil {
+		peerPath = p.bounded.GetLeast(key)
+	} else {
+		peerPath = p.picker.Get(key)
+	}
+	if peerPath != "" && peerPath != p.selfPath {
+		c, ok := p.httpClient[peerPath]
+		if !ok || !c.available() {
+			p.Log("Peer %s is unavailable", peerPath)
+			return nil, false
+		}
 		p.Log("Pick Peer %s", peerPath)
-		return p.httpClient[peerPath], true
+		return c, true
 	}
 	return nil, false
 }
 
+// PickOwner 返回负责key的节点, 用于写入和删除, 不受有界负载和熔断的影响
+func (p *HttpServer) PickOwner(key string) (PeerServer, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if peerPath := p.picker.Get(key); peerPath != "" && peerPath != p.selfPath {
+		if c, ok := p.httpClient[peerPath]; ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// PickReplicas 返回key的最多n个副本所在的节点, 本节点为nil, 熔断的节点会被跳过。
+// Picker 不支持多个副本时只返回负责的节点。
+func (p *HttpServer) PickReplicas(key string, n int) []PeerServer {
+	return p.replicas(key, n, true)
+}
+
+// PickOwnerReplicas 与 PickReplicas 相同, 但是不跳过熔断的节点, 用于写入和删除
+func (p *HttpServer) PickOwnerReplicas(key string, n int) []PeerServer {
+	return p.replicas(key, n, false)
+}
+
+func (p *HttpServer) replicas(key string, n int, skipOpen bool) []PeerServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var peersPath []string
+	if replica, ok := p.picker.(consistenthash.ReplicaPicker); ok {
+		peersPath = replica.GetN(key, n)
+	} else if peerPath := p.picker.Get(key); peerPath != "" {
+		peersPath = []string{peerPath}
+	}
+	peers := make([]PeerServer, 0, len(peersPath))
+	for _, peerPath := range peersPath {
+		if peerPath == p.selfPath {
+			peers = append(peers, nil)
+		} else if c, ok := p.httpClient[peerPath]; ok && (!skipOpen || c.available()) {
+			peers = append(peers, c)
+		}
+	}
+	return peers
+}
+
 // HTTP客户端类
 type httpClient struct {
+	// 节点地址, 例如 "http://10.0.0.2:8008"
+	peer    string
 	baseURL string
+	// 每个节点使用单独的连接池, 节点离开时可以单独关闭
+	client *http.Client
+	config transportConfig
+	// 节点离开集群后为1
+	closed int32
+	// 请求开始和结束时调用, 统计节点的负载, 可以为nil
+	track func(peer string, delta int)
+	// 熔断器, 为nil时不使用
+	breaker *breaker
+	// 请求的耗时, 节点离开时一起删除
+	latency *metrics.Histogram
+}
+
+// 记录一次请求的耗时
+func (h *httpClient) observe(start time.Time) {
+	h.latency.Observe(time.Since(start))
+}
+
+func newHttpClient(peer, baseURL string, config transportConfig) *httpClient {
+	return &httpClient{
+		peer:    peer,
+		baseURL: baseURL,
+		client:  config.newClient(),
+		config:  config,
+		latency: metrics.NewHistogram(nil),
+	}
+}
+
+// 关闭空闲连接, 之后的请求直接返回错误
+func (h *httpClient) close() {
+	atomic.StoreInt32(&h.closed, 1)
+	h.client.CloseIdleConnections()
 }
 
 // 实现HTTP客户端接口, 这是用来发送请求的.
-func (h *httpClient) Get(in *pb.Request, out *pb.Response) error {
-	u := fmt.Sprintf(
-		"%v/%v/%v",
-		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
-	)
-	// 发送HTTP请求, 获取返回值
-	res, err := http.Get(u)
+// ctx 的超时和取消会作用于整个HTTP请求.
+func (h *httpClient) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	// 返回体为[]byte
+	b, err := h.do(ctx, http.MethodGet, keyPath(in.GetGroup(), in.GetKey()), nil)
+	if errors.Is(err, ErrNotFound) {
+		return notFound(in.GetKey())
+	}
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+	if err = proto.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
 	}
 
-	// 返回体为[]byte
-	bytes, err := ioutil.ReadAll(res.Body)
+	return nil
+}
+
+// 写入远程节点, 请求体为value
+func (h *httpClient) Set(ctx context.Context, in *pb.SetRequest, out *pb.Ack) error {
+	_, err := h.send(ctx, http.MethodPut, keyPath(in.GetGroup(), in.GetKey()), in.GetValue())
+	return err
+}
+
+// 删除远程节点的key
+func (h *httpClient) Remove(ctx context.Context, in *pb.Request, out *pb.Ack) error {
+	_, err := h.send(ctx, http.MethodDelete, keyPath(in.GetGroup(), in.GetKey()), nil)
+	return err
+}
+
+// 批量读取, 请求体和响应体都是protobuf
+func (h *httpClient) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	body, err := proto.Marshal(in)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return err
 	}
-
-	if err = proto.Unmarshal(bytes, out); err != nil {
+	b, err := h.do(ctx, http.MethodPost, url.PathEscape(in.GetGroup()), body)
+	if err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(b, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
-
 	return nil
 }
+
+// baseURL 已经以 / 结尾; 路径中不能使用 QueryEscape, 否则空格会被编码为 +
+func keyPath(group, key string) string {
+	return url.PathEscape(group) + "/" + url.PathEscape(key)
+}
+
+// 发送读取请求, 返回响应体. path 为 baseURL 之后的部分
+func (h *httpClient) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	// 选择节点时不占用 half-open 的探测机会, 发送请求时才占用
+	if h.breaker != nil && !h.breaker.allow() {
+		return nil, errBreakerOpen
+	}
+	start := time.Now()
+	b, err := h.send(ctx, method, path, body)
+	// 包括重试在内的结果和耗时反馈给熔断器
+	if h.breaker != nil {
+		if failed, ok := peerFailed(ctx, err); ok {
+			h.breaker.record(failed, time.Since(start))
+		}
+	}
+	return b, err
+}
+
+// 发送HTTP请求并统计节点的负载, 不经过熔断器。
+// 写入和删除直接使用: 必须到达负责的节点, 失败时返回错误, 结果也不反馈给熔断器
+func (h *httpClient) send(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	if h.track != nil {
+		h.track(h.peer, 1)
+		defer h.track(h.peer, -1)
+	}
+	return h.retry(ctx, method, path, body)
+}
+
+// 连接失败, 429和503会按照配置重试, 其他错误直接返回
+func (h *httpClient) retry(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		b, err := h.doOnce(ctx, method, path, body)
+		var transient *transientError
+		if !errors.As(err, &transient) {
+			return b, err
+		}
+		if attempt >= h.config.retries || !h.config.wait(ctx, attempt) {
+			return nil, transient.err
+		}
+	}
+}
+
+// 发送一次HTTP请求, 可以重试的错误包装为 *transientError
+func (h *httpClient) doOnce(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	if atomic.LoadInt32(&h.closed) == 1 {
+		return nil, errPeerClosed
+	}
+	u := h.baseURL + path
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// 发送HTTP请求, 获取返回值
+	defer h.observe(time.Now())
+	res, err := h.client.Do(req)
+	if err != nil {
+		if isTransient(ctx, err) {
+			return nil, &transientError{err}
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound && res.Header.Get(notFoundHeader) != "" {
+		return nil, ErrNotFound
+	}
+	if res.StatusCode == http.StatusBadGateway {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, h.config.maxResponseBytes))
+		return nil, ownerLoadError(strings.TrimSpace(string(b)))
+	}
+	// 对方过载, 暂时拒绝请求
+	if res.StatusCode == http.StatusServiceUnavailable || res.StatusCode == http.StatusTooManyRequests {
+		return nil, &transientError{fmt.Errorf("server returned: %v", res.Status)}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned: %v", res.Status)
+	}
+
+	// 多读一个字节, 判断是否超过了最大长度
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, h.config.maxResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	if int64(len(b)) > h.config.maxResponseBytes {
+		return nil, errResponseTooLarge
+	}
+	return b, nil
+}
@@ -0,0 +1,605 @@
+package membership
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	基于 SWIM 协议的集群成员管理
+
+	每隔 ProbeInterval 选择一个成员发送 ping:
+	ping ---> 收到 ack ---> 存活
+	  |  ProbeTimeout 内没有 ack
+	  |---> 请 IndirectChecks 个成员代为 ping (ping-req) ---> 收到转发的 ack ---> 存活
+	             |  本轮结束仍然没有 ack
+	             |---> 怀疑(suspect) ---> SuspicionTimeout 内没有反驳 ---> 死亡(dead)
+
+	成员状态的变化不单独发送, 而是附带在 ping/ack 等消息中传播(piggyback)。
+	被怀疑的成员收到关于自己的怀疑后, 增加自己的 incarnation 并广播存活, 以此反驳。
+*/
+
+// State 成员状态
+type State int
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	default:
+		return "dead"
+	}
+}
+
+// Member 集群成员
+type Member struct {
+	// 成员之间通信的地址
+	Addr string `json:"addr"`
+	// 成员的元数据, 例如缓存节点的地址 "http://10.0.0.2:8001"
+	Meta        string `json:"meta"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"inc"`
+}
+
+// Config 成员管理的配置, 零值使用默认值
+type Config struct {
+	// 本节点的地址, 其他成员通过这个地址访问本节点
+	Addr string
+	// 本节点的元数据
+	Meta string
+	// 探测周期
+	ProbeInterval time.Duration
+	// 等待 ack 的时间, 超时后发送 ping-req
+	ProbeTimeout time.Duration
+	// 发送 ping-req 的成员数量
+	IndirectChecks int
+	// 被怀疑的成员在这段时间内没有反驳就被认为已经死亡
+	SuspicionTimeout time.Duration
+	// 每条消息最多附带的状态变化
+	MaxPiggyback int
+	// 每个状态变化传播 RetransmitMult * log(N+1) 次
+	RetransmitMult int
+	// 存活(包括被怀疑)的成员发生变化时调用, members 包括本节点, 按地址排序
+	OnChange func(members []Member)
+}
+
+const (
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 300 * time.Millisecond
+	defaultIndirectChecks   = 3
+	defaultSuspicionTimeout = 3 * time.Second
+	defaultMaxPiggyback     = 8
+	defaultRetransmitMult   = 4
+)
+
+// 消息类型
+type msgType int
+
+const (
+	pingMsg msgType = iota
+	pingReqMsg
+	ackMsg
+	joinMsg
+	syncMsg
+	gossipMsg
+)
+
+// 节点之间传递的消息
+type message struct {
+	Type msgType `json:"type"`
+	// 发送方的地址
+	From string `json:"from"`
+	Seq  uint64 `json:"seq,omitempty"`
+	// ping-req 要探测的成员
+	Target string `json:"target,omitempty"`
+	// 附带的状态变化, sync 消息中为所有成员
+	Updates []Member `json:"updates,omitempty"`
+}
+
+// 等待广播的状态变化
+type broadcast struct {
+	member    Member
+	transmits int
+}
+
+// Node 集群中的一个成员
+type Node struct {
+	cfg       Config
+	transport Transport
+
+	mu      sync.Mutex
+	members map[string]*Member
+	// 等待 ack 的回调, keyed by seq
+	acks map[uint64]func()
+	seq  uint64
+	// 探测顺序, 每轮打乱一次
+	probeList  []string
+	probeIndex int
+	broadcasts []*broadcast
+	leaving    bool
+
+	// 成员变化通知
+	changed chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New 创建成员并开始探测, 需要调用 Join 加入集群
+func New(transport Transport, cfg Config) *Node {
+	if cfg.ProbeInterval == 0 {
+		cfg.ProbeInterval = defaultProbeInterval
+	}
+	if cfg.ProbeTimeout == 0 {
+		cfg.ProbeTimeout = defaultProbeTimeout
+	}
+	if cfg.IndirectChecks == 0 {
+		cfg.IndirectChecks = defaultIndirectChecks
+	}
+	if cfg.SuspicionTimeout == 0 {
+		cfg.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if cfg.MaxPiggyback == 0 {
+		cfg.MaxPiggyback = defaultMaxPiggyback
+	}
+	if cfg.RetransmitMult == 0 {
+		cfg.RetransmitMult = defaultRetransmitMult
+	}
+	n := &Node{
+		cfg:       cfg,
+		transport: transport,
+		members:   make(map[string]*Member),
+		acks:      make(map[uint64]func()),
+		changed:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	// 使用当前时间作为初始的 incarnation, 重启后的节点不会被旧的死亡消息拦住
+	self := Member{Addr: cfg.Addr, Meta: cfg.Meta, State: StateAlive, Incarnation: uint64(time.Now().UnixNano())}
+	n.members[cfg.Addr] = &self
+	n.queueBroadcast(self)
+	n.notify()
+
+	n.wg.Add(3)
+	go n.receiveLoop()
+	go n.probeLoop()
+	go n.notifyLoop()
+	return n
+}
+
+// Join 通过种子节点加入集群, 至少一个种子节点回复时返回nil
+func (n *Node) Join(seeds ...string) error {
+	done := make(chan struct{})
+	var once sync.Once
+	n.mu.Lock()
+	seq := n.nextSeq()
+	n.acks[seq] = func() { once.Do(func() { close(done) }) }
+	self := *n.members[n.cfg.Addr]
+	n.mu.Unlock()
+	defer n.clearAck(seq)
+
+	contacted := 0
+	for _, seed := range seeds {
+		if seed == n.cfg.Addr {
+			continue
+		}
+		contacted++
+		n.send(seed, &message{Type: joinMsg, Seq: seq, Updates: []Member{self}})
+	}
+	if contacted == 0 {
+		// 第一个节点
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(n.cfg.ProbeInterval + n.cfg.ProbeTimeout):
+		return errors.New("membership: no seed responded")
+	}
+}
+
+// Leave 通知其他成员本节点主动离开, 之后需要调用 Close
+func (n *Node) Leave() {
+	n.mu.Lock()
+	n.leaving = true
+	self := n.members[n.cfg.Addr]
+	self.State = StateDead
+	self.Incarnation++
+	update := *self
+	var targets []string
+	for addr, m := range n.members {
+		if addr != n.cfg.Addr && m.State != StateDead {
+			targets = append(targets, addr)
+		}
+	}
+	n.mu.Unlock()
+	// 直接通知所有成员, 不等待传播
+	for _, addr := range targets {
+		n.send(addr, &message{Type: gossipMsg, Updates: []Member{update}})
+	}
+}
+
+// Close 停止探测并关闭 Transport
+func (n *Node) Close() error {
+	select {
+	case <-n.stop:
+		return nil
+	default:
+	}
+	close(n.stop)
+	err := n.transport.Close()
+	n.wg.Wait()
+	return err
+}
+
+// Members 返回存活(包括被怀疑)的成员, 包括本节点, 按地址排序
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.aliveMembers()
+}
+
+func (n *Node) aliveMembers() []Member {
+	members := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		if m.State != StateDead {
+			members = append(members, *m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	return members
+}
+
+func (n *Node) nextSeq() uint64 {
+	n.seq++
+	return n.seq
+}
+
+func (n *Node) clearAck(seq uint64) {
+	n.mu.Lock()
+	delete(n.acks, seq)
+	n.mu.Unlock()
+}
+
+// 发送消息, 附带等待广播的状态变化
+func (n *Node) send(addr string, msg *message) {
+	msg.From = n.cfg.Addr
+	if msg.Type != syncMsg {
+		n.mu.Lock()
+		msg.Updates = append(msg.Updates, n.takeBroadcasts()...)
+		n.mu.Unlock()
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("[Membership] encode message failed:", err)
+		return
+	}
+	n.transport.WriteTo(b, addr)
+}
+
+func (n *Node) receiveLoop() {
+	defer n.wg.Done()
+	for packet := range n.transport.Packets() {
+		var msg message
+		if err := json.Unmarshal(packet.Data, &msg); err != nil {
+			continue
+		}
+		n.handle(&msg)
+	}
+}
+
+func (n *Node) handle(msg *message) {
+	if msg.Type != syncMsg {
+		n.applyUpdates(msg.Updates)
+	}
+	switch msg.Type {
+	case pingMsg:
+		n.send(msg.From, &message{Type: ackMsg, Seq: msg.Seq})
+	case pingReqMsg:
+		// 代为探测 Target, 收到 ack 后转发给请求方
+		origin, originSeq := msg.From, msg.Seq
+		n.mu.Lock()
+		seq := n.nextSeq()
+		n.acks[seq] = func() {
+			n.send(origin, &message{Type: ackMsg, Seq: originSeq})
+		}
+		n.mu.Unlock()
+		time.AfterFunc(n.cfg.ProbeTimeout, func() { n.clearAck(seq) })
+		n.send(msg.Target, &message{Type: pingMsg, Seq: seq})
+	case ackMsg, syncMsg:
+		if msg.Type == syncMsg {
+			n.applyUpdates(msg.Updates)
+		}
+		n.mu.Lock()
+		fn, ok := n.acks[msg.Seq]
+		delete(n.acks, msg.Seq)
+		n.mu.Unlock()
+		if ok {
+			fn()
+		}
+	case joinMsg:
+		// 回复所有成员的状态
+		n.mu.Lock()
+		all := make([]Member, 0, len(n.members))
+		for _, m := range n.members {
+			all = append(all, *m)
+		}
+		n.mu.Unlock()
+		n.send(msg.From, &message{Type: syncMsg, Seq: msg.Seq, Updates: all})
+	}
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.probe()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// 探测一个成员
+func (n *Node) probe() {
+	n.mu.Lock()
+	target, ok := n.nextProbeTarget()
+	if !ok {
+		n.mu.Unlock()
+		return
+	}
+	acked := make(chan struct{})
+	var once sync.Once
+	seq := n.nextSeq()
+	n.acks[seq] = func() { once.Do(func() { close(acked) }) }
+	n.mu.Unlock()
+	defer n.clearAck(seq)
+
+	n.send(target, &message{Type: pingMsg, Seq: seq})
+	select {
+	case <-acked:
+		return
+	case <-time.After(n.cfg.ProbeTimeout):
+	case <-n.stop:
+		return
+	}
+
+	// 间接探测
+	n.mu.Lock()
+	relays := n.randomMembers(n.cfg.IndirectChecks, target)
+	n.mu.Unlock()
+	for _, relay := range relays {
+		n.send(relay, &message{Type: pingReqMsg, Seq: seq, Target: target})
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(n.cfg.ProbeInterval - n.cfg.ProbeTimeout):
+	case <-n.stop:
+		return
+	}
+
+	n.mu.Lock()
+	if m, ok := n.members[target]; ok && m.State == StateAlive {
+		n.log("suspect %s", target)
+		n.applyLocked(Member{Addr: m.Addr, Meta: m.Meta, State: StateSuspect, Incarnation: m.Incarnation})
+	}
+	n.mu.Unlock()
+}
+
+// 按照打乱后的顺序轮流选择探测目标, 保证每个成员在有限时间内都会被探测到
+func (n *Node) nextProbeTarget() (string, bool) {
+	for i := 0; i <= len(n.probeList); i++ {
+		if n.probeIndex >= len(n.probeList) {
+			n.probeList = n.probeList[:0]
+			for addr, m := range n.members {
+				if addr != n.cfg.Addr && m.State != StateDead {
+					n.probeList = append(n.probeList, addr)
+				}
+			}
+			rand.Shuffle(len(n.probeList), func(i, j int) {
+				n.probeList[i], n.probeList[j] = n.probeList[j], n.probeList[i]
+			})
+			n.probeIndex = 0
+			if len(n.probeList) == 0 {
+				return "", false
+			}
+		}
+		addr := n.probeList[n.probeIndex]
+		n.probeIndex++
+		if m, ok := n.members[addr]; ok && m.State != StateDead {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// 随机选择k个存活的成员, 不包括本节点和exclude
+func (n *Node) randomMembers(k int, exclude string) []string {
+	var addrs []string
+	for addr, m := range n.members {
+		if addr != n.cfg.Addr && addr != exclude && m.State == StateAlive {
+			addrs = append(addrs, addr)
+		}
+	}
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > k {
+		addrs = addrs[:k]
+	}
+	return addrs
+}
+
+func (n *Node) applyUpdates(updates []Member) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, u := range updates {
+		n.applyLocked(u)
+	}
+}
+
+// 合并一个状态变化, 状态改变时继续传播
+// incarnation 更大的消息更新; incarnation 相同时 dead > suspect > alive
+func (n *Node) applyLocked(u Member) {
+	if u.Addr == n.cfg.Addr {
+		n.refuteLocked(u)
+		return
+	}
+	cur, ok := n.members[u.Addr]
+	if ok {
+		if u.Incarnation < cur.Incarnation || (u.Incarnation == cur.Incarnation && u.State <= cur.State) {
+			return
+		}
+	} else if u.State == StateDead {
+		// 不认识的成员已经死亡, 不需要记录
+		return
+	}
+	wasAlive := ok && cur.State != StateDead
+	m := u
+	n.members[u.Addr] = &m
+	n.queueBroadcast(m)
+
+	switch m.State {
+	case StateSuspect:
+		// 超时后仍然是这一次怀疑, 则认为已经死亡
+		time.AfterFunc(n.cfg.SuspicionTimeout, func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if cur, ok := n.members[m.Addr]; ok && cur.State == StateSuspect && cur.Incarnation == m.Incarnation {
+				n.log("%s is dead", m.Addr)
+				n.applyLocked(Member{Addr: m.Addr, Meta: m.Meta, State: StateDead, Incarnation: m.Incarnation})
+			}
+		})
+	case StateAlive:
+		if !wasAlive {
+			n.log("%s joined", m.Addr)
+			n.notify()
+		}
+	case StateDead:
+		if wasAlive {
+			n.notify()
+		}
+	}
+}
+
+// 收到关于本节点的怀疑或死亡消息时, 增加 incarnation 并广播存活
+func (n *Node) refuteLocked(u Member) {
+	self := n.members[n.cfg.Addr]
+	if n.leaving || u.State == StateAlive || u.Incarnation < self.Incarnation {
+		return
+	}
+	self.Incarnation = u.Incarnation + 1
+	n.log("refute %s with incarnation %d", u.State, self.Incarnation)
+	n.queueBroadcast(*self)
+}
+
+func (n *Node) queueBroadcast(m Member) {
+	// 同一个成员只保留最新的状态
+	for i, b := range n.broadcasts {
+		if b.member.Addr == m.Addr {
+			n.broadcasts = append(n.broadcasts[:i], n.broadcasts[i+1:]...)
+			break
+		}
+	}
+	n.broadcasts = append(n.broadcasts, &broadcast{member: m})
+}
+
+// 取出最多 MaxPiggyback 个状态变化, 优先传播次数少的
+func (n *Node) takeBroadcasts() []Member {
+	if len(n.broadcasts) == 0 {
+		return nil
+	}
+	limit := n.cfg.RetransmitMult * int(math.Ceil(math.Log2(float64(len(n.members)+1))))
+	sort.SliceStable(n.broadcasts, func(i, j int) bool {
+		return n.broadcasts[i].transmits < n.broadcasts[j].transmits
+	})
+	var updates []Member
+	kept := n.broadcasts[:0]
+	for _, b := range n.broadcasts {
+		if len(updates) < n.cfg.MaxPiggyback {
+			updates = append(updates, b.member)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	n.broadcasts = kept
+	return updates
+}
+
+// 通知 notifyLoop 成员发生了变化
+func (n *Node) notify() {
+	select {
+	case n.changed <- struct{}{}:
+	default:
+	}
+}
+
+// 在单独的 goroutine 中调用 OnChange, 保证回调的顺序与成员变化的顺序相同
+func (n *Node) notifyLoop() {
+	defer n.wg.Done()
+	var last []Member
+	for {
+		select {
+		case <-n.changed:
+		case <-n.stop:
+			return
+		}
+		if n.cfg.OnChange == nil {
+			continue
+		}
+		members := n.Members()
+		if sameMembers(last, members) {
+			continue
+		}
+		last = members
+		n.cfg.OnChange(members)
+	}
+}
+
+func sameMembers(a, b []Member) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Addr != b[i].Addr || a[i].Meta != b[i].Meta {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *Node) log(format string, v ...interface{}) {
+	log.Printf("[Membership %s] "+format, append([]interface{}{n.cfg.Addr}, v...)...)
+}
+
+// PeerSetter 根据成员变化更新缓存节点, 例如 cache.HttpServer 和 cache.GrpcServer
+type PeerSetter interface {
+	SetPeers(peers ...string)
+}
+
+// UpdatePeers 返回可以作为 Config.OnChange 的回调, 用存活成员的 Meta 替换 setter 中的节点
+func UpdatePeers(setter PeerSetter) func(members []Member) {
+	return func(members []Member) {
+		peers := make([]string, 0, len(members))
+		for _, m := range members {
+			peers = append(peers, m.Meta)
+		}
+		setter.SetPeers(peers...)
+	}
+}
@@ -0,0 +1,138 @@
+package membership
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录 OnChange 收到的最新成员
+type peerRecorder struct {
+	mu    sync.Mutex
+	peers []string
+}
+
+func (r *peerRecorder) SetPeers(peers ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = peers
+}
+
+func (r *peerRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peers
+}
+
+func testConfig(addr string, r *peerRecorder) Config {
+	return Config{
+		Addr:             addr,
+		Meta:             "http://" + addr,
+		ProbeInterval:    50 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		SuspicionTimeout: 150 * time.Millisecond,
+		OnChange:         UpdatePeers(r),
+	}
+}
+
+func startCluster(t *testing.T, network *MemNetwork, count int) ([]*Node, []*peerRecorder) {
+	nodes := make([]*Node, count)
+	recorders := make([]*peerRecorder, count)
+	for i := 0; i < count; i++ {
+		addr := fmt.Sprintf("node%d", i)
+		recorders[i] = &peerRecorder{}
+		nodes[i] = New(network.NewTransport(addr), testConfig(addr, recorders[i]))
+		// 只需要知道第一个节点
+		if err := nodes[i].Join("node0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.Close()
+		}
+	})
+	return nodes, recorders
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func metas(addrs ...string) []string {
+	peers := make([]string, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = "http://" + addr
+	}
+	return peers
+}
+
+func TestJoin(t *testing.T) {
+	_, recorders := startCluster(t, NewMemNetwork(), 4)
+	expect := metas("node0", "node1", "node2", "node3")
+	for i, r := range recorders {
+		waitFor(t, 2*time.Second, func() bool { return reflect.DeepEqual(r.get(), expect) },
+			fmt.Sprintf("node%d should see all members, got %v", i, r.get()))
+	}
+}
+
+func TestFailureDetection(t *testing.T) {
+	nodes, recorders := startCluster(t, NewMemNetwork(), 4)
+	expect := metas("node0", "node1", "node2", "node3")
+	for _, r := range recorders {
+		waitFor(t, 2*time.Second, func() bool { return reflect.DeepEqual(r.get(), expect) }, "cluster should converge")
+	}
+
+	// node2 直接退出, 不通知其他成员
+	nodes[2].Close()
+	expect = metas("node0", "node1", "node3")
+	for i, r := range recorders {
+		if i == 2 {
+			continue
+		}
+		waitFor(t, 2*time.Second, func() bool { return reflect.DeepEqual(r.get(), expect) },
+			fmt.Sprintf("node%d should detect node2 failure, got %v", i, r.get()))
+	}
+}
+
+func TestLeave(t *testing.T) {
+	nodes, recorders := startCluster(t, NewMemNetwork(), 3)
+	for _, r := range recorders {
+		waitFor(t, 2*time.Second, func() bool { return len(r.get()) == 3 }, "cluster should converge")
+	}
+
+	nodes[1].Leave()
+	nodes[1].Close()
+	expect := metas("node0", "node2")
+	for _, i := range []int{0, 2} {
+		r := recorders[i]
+		// 主动离开不需要等待怀疑超时
+		waitFor(t, 100*time.Millisecond, func() bool { return reflect.DeepEqual(r.get(), expect) },
+			fmt.Sprintf("node%d should see node1 leave, got %v", i, r.get()))
+	}
+}
+
+func TestRefute(t *testing.T) {
+	network := NewMemNetwork()
+	r := &peerRecorder{}
+	n := New(network.NewTransport("node0"), testConfig("node0", r))
+	defer n.Close()
+
+	self := n.Members()[0]
+	n.applyUpdates([]Member{{Addr: "node0", State: StateSuspect, Incarnation: self.Incarnation}})
+	if inc := n.Members()[0].Incarnation; inc != self.Incarnation+1 {
+		t.Fatalf("node should refute suspicion with a larger incarnation, got %d", inc)
+	}
+	if m := n.Members(); len(m) != 1 || m[0].State != StateAlive {
+		t.Fatalf("node should stay alive, got %v", m)
+	}
+}
@@ -0,0 +1,142 @@
+package membership
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// 节点之间使用UDP数据包通信, 数据包可能丢失, 不保证顺序
+
+// 单个数据包的最大长度
+const maxPacketSize = 65507
+
+// Packet 收到的数据包
+type Packet struct {
+	Data []byte
+}
+
+// Transport 发送和接收数据包, 发送失败不需要返回错误(与UDP相同)
+type Transport interface {
+	// WriteTo 发送数据包到addr
+	WriteTo(b []byte, addr string) error
+	// Packets 返回收到的数据包, Close之后被关闭
+	Packets() <-chan Packet
+	Close() error
+}
+
+// UDP 实现的 Transport
+type udpTransport struct {
+	conn    net.PacketConn
+	packets chan Packet
+}
+
+// NewUDPTransport 监听UDP地址addr, 例如 "0.0.0.0:7946"
+func NewUDPTransport(addr string) (Transport, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &udpTransport{conn: conn, packets: make(chan Packet, 1024)}
+	go t.readLoop()
+	return t, nil
+}
+
+func (t *udpTransport) readLoop() {
+	defer close(t.packets)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		select {
+		case t.packets <- Packet{Data: data}:
+		default:
+			// 来不及处理, 与网络丢包相同
+		}
+	}
+}
+
+func (t *udpTransport) WriteTo(b []byte, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = t.conn.WriteTo(b, udpAddr)
+	return err
+}
+
+func (t *udpTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// MemNetwork 内存中模拟的UDP网络, 用于测试
+type MemNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*memTransport
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{nodes: make(map[string]*memTransport)}
+}
+
+// NewTransport 在网络中加入地址为addr的节点
+func (n *MemNetwork) NewTransport(addr string) Transport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t := &memTransport{network: n, addr: addr, packets: make(chan Packet, 1024)}
+	n.nodes[addr] = t
+	return t
+}
+
+type memTransport struct {
+	network *MemNetwork
+	addr    string
+	packets chan Packet
+	once    sync.Once
+}
+
+func (t *memTransport) WriteTo(b []byte, addr string) error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	if t.network.nodes[t.addr] != t {
+		return errors.New("transport closed")
+	}
+	to, ok := t.network.nodes[addr]
+	if !ok {
+		// 对方不存在, 数据包丢失
+		return nil
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	select {
+	case to.packets <- Packet{Data: data}:
+	default:
+	}
+	return nil
+}
+
+func (t *memTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+// Close 离开网络, 之后发给这个节点的数据包都会丢失
+func (t *memTransport) Close() error {
+	t.once.Do(func() {
+		t.network.mu.Lock()
+		defer t.network.mu.Unlock()
+		delete(t.network.nodes, t.addr)
+		close(t.packets)
+	})
+	return nil
+}
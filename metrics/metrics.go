@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 以Prometheus文本格式(text/plain; version=0.0.4)输出指标
+
+// ContentType Prometheus文本格式的Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets 默认的延迟分桶, 单位为秒
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Labels 将成对的标签名和标签值格式化为 {k1="v1",k2="v2"}
+func Labels(kv ...string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(kv[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// WriteHeader 输出一个指标的 HELP 和 TYPE, 同名的指标只需要输出一次
+func WriteHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// WriteSample 输出一个样本, labels 由 Labels 生成
+func WriteSample(w io.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Histogram 并发安全的直方图, 分桶在创建之后不能修改
+type Histogram struct {
+	// 每个桶的上界, 升序
+	buckets []float64
+	// counts[i] 为落在 (buckets[i-1], buckets[i]] 中的样本数, 最后一个为 +Inf
+	counts []uint64
+	count  uint64
+	// 样本之和, 单位为纳秒
+	sumNanos int64
+}
+
+// NewHistogram 创建直方图, buckets 为空时使用 DefBuckets
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe 记录一次耗时
+func (h *Histogram) Observe(d time.Duration) {
+	s := d.Seconds()
+	i := 0
+	for i < len(h.buckets) && s > h.buckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sumNanos, int64(d))
+}
+
+// Count 返回样本数
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Write 输出 name_bucket, name_sum, name_count, labels 由 Labels 生成
+func (h *Histogram) Write(w io.Writer, name, labels string) {
+	// 在已有的标签后面追加 le
+	prefix := "{"
+	if labels != "" {
+		prefix = labels[:len(labels)-1] + ","
+	}
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		fmt.Fprintf(w, "%s_bucket%sle=\"%s\"} %d\n", name, prefix, formatFloat(upper), cumulative)
+	}
+	cumulative += atomic.LoadUint64(&h.counts[len(h.buckets)])
+	fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", name, prefix, cumulative)
+	WriteSample(w, name+"_sum", labels, time.Duration(atomic.LoadInt64(&h.sumNanos)).Seconds())
+	WriteSample(w, name+"_count", labels, float64(cumulative))
+}
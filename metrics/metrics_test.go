@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLabels(t *testing.T) {
+	if s := Labels(); s != "" {
+		t.Fatalf("expect empty labels, got %s", s)
+	}
+	if s := Labels("group", "scores", "peer", `a"b\`); s != `{group="scores",peer="a\"b\\"}` {
+		t.Fatalf("unexpected labels %s", s)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.01, 0.1})
+	h.Observe(5 * time.Millisecond)
+	h.Observe(50 * time.Millisecond)
+	h.Observe(time.Second)
+	if h.Count() != 3 {
+		t.Fatalf("expect 3 samples, got %d", h.Count())
+	}
+
+	var b strings.Builder
+	h.Write(&b, "latency_seconds", Labels("peer", "p1"))
+	expect := `latency_seconds_bucket{peer="p1",le="0.01"} 1
+latency_seconds_bucket{peer="p1",le="0.1"} 2
+latency_seconds_bucket{peer="p1",le="+Inf"} 3
+latency_seconds_sum{peer="p1"} 1.055
+latency_seconds_count{peer="p1"} 3
+`
+	if b.String() != expect {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+
+	b.Reset()
+	NewHistogram(nil).Write(&b, "empty", "")
+	if !strings.HasPrefix(b.String(), `empty_bucket{le="0.0005"} 0`) {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"mini-cache/view"
+	"time"
+)
+
+// ErrNotFound 表示数据源中没有这个key。
+// 回调函数返回 ErrNotFound (或者用 %w 包装它) 时, Group 会把这个结果缓存一段时间(负缓存),
+// 在此期间同一个key的请求直接返回 ErrNotFound, 不再访问数据源。
+// 远程节点之间也会传递这个结果, 调用方不会回退到本地数据源。
+var ErrNotFound = errors.New("key not found")
+
+const (
+	// 负缓存默认的过期时间, 应当比正常数据短
+	defaultNegativeTTL = 5 * time.Second
+	// 负缓存默认最多保存的key数量
+	defaultNegativeKeys = 10000
+)
+
+// 负缓存中保存的值, 每个key占用一个字节, 容量即为key的数量
+var negativeMarker = view.ByteView{B: []byte{0}}
+
+// 包装 ErrNotFound, 保留key方便定位问题
+func notFound(key string) error {
+	return fmt.Errorf("%w: %s", ErrNotFound, key)
+}
+
+// 命中负缓存时返回true
+func (g *Group) lookupNegative(key string) bool {
+	if g.negCache == nil {
+		return false
+	}
+	if _, ok := g.negCache.Get(key); ok {
+		g.Stats.NegativeHits.Add(1)
+		return true
+	}
+	return false
+}
+
+// 记录数据源中不存在的key
+func (g *Group) populateNegative(key string) {
+	if g.negCache != nil {
+		g.negCache.AddWithTTL(key, negativeMarker, g.negativeTTL)
+	}
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	concurrentcache "mini-cache/concurrent-cache"
+	"time"
+)
+
+// GroupOption 用于在创建 Group 时修改默认配置
+type GroupOption func(*Group)
+
+// WithDefaultTTL 设置缓存的默认过期时间, 回调函数没有指定过期时间时使用。
+// ttl <= 0 表示永不过期。
+func WithDefaultTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.defaultTTL = ttl
+	}
+}
+
+// WithEvictionPolicy 设置缓存的淘汰策略, 例如 concurrentcache.NewLFU。
+// 默认使用 concurrentcache.NewLRU。
+func WithEvictionPolicy(newPolicy concurrentcache.NewPolicyFunc) GroupOption {
+	return func(g *Group) {
+		g.newPolicy = newPolicy
+	}
+}
+
+// WithHotCache 开启热点缓存, 设置容量和采样比例。
+// 从远程节点获取的数据有 1/sampleRate 的概率被写入热点缓存, maxBytes <= 0 时不使用热点缓存。
+// Set 和 Remove 不会通知其他节点的热点缓存, 因此热点数据最多保留 1min(默认过期时间更短时使用默认过期时间),
+// 这段时间内可能读到旧值。默认不使用, 采样比例默认为 10。
+func WithHotCache(maxBytes int64, sampleRate int) GroupOption {
+	return func(g *Group) {
+		g.hotCacheBytes = maxBytes
+		if sampleRate > 0 {
+			g.hotSampleRate = sampleRate
+		}
+	}
+}
+
+// WithBatchWindow 设置合并载入的时间窗口和每批的最大数量, 只在回调函数实现了 BatchGettr 时生效。
+// 第一个未命中的key到达之后等待 window, 或者收集到 maxSize 个key时立即调用 BatchGettr.GetMany。
+// 默认为1ms和100。
+func WithBatchWindow(window time.Duration, maxSize int) GroupOption {
+	return func(g *Group) {
+		if window > 0 {
+			g.batchWindow = window
+		}
+		if maxSize > 0 {
+			g.batchMaxSize = maxSize
+		}
+	}
+}
+
+// 使用 NewGroup 创建时, Gettr 被适配为 ContextGettr 之后无法再检测 BatchGettr, 通过这个选项传入
+func withBatchGettr(gettr BatchGettr) GroupOption {
+	return func(g *Group) {
+		g.batchGettr = gettr
+	}
+}
+
+// WithNegativeCache 设置负缓存的过期时间和最多保存的key数量。
+// 回调函数返回 ErrNotFound 的key会被缓存 ttl, ttl <= 0 或 maxKeys <= 0 时不使用负缓存。
+// 默认为5s和10000。
+func WithNegativeCache(ttl time.Duration, maxKeys int) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+		g.negativeKeys = maxKeys
+	}
+}
+
+// WithStaleWhileRevalidate 设置过期之后仍然可以返回旧值的时间。
+// 数据在过期时间(软过期)之后、再过 stale 之前(硬过期)被访问时立即返回旧值, 同时在后台刷新一次;
+// 硬过期之后才视为未命中。只对设置了过期时间的数据生效。
+func WithStaleWhileRevalidate(stale time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleTTL = stale
+	}
+}
+
+// WithRefreshAhead 设置提前刷新的比例, 取值为 (0, 1)。
+// 数据的有效期过去 factor 之后被访问时在后台刷新, 一直被访问的key不会过期。
+// 例如过期时间为1分钟, factor 为0.8, 写入48秒之后的访问会触发刷新。
+func WithRefreshAhead(factor float64) GroupOption {
+	return func(g *Group) {
+		if factor > 0 && factor < 1 {
+			g.refreshAhead = factor
+		}
+	}
+}
+
+// WithStaleIfError 保留最近被淘汰或者过期的数据, 最多 maxBytes, 每个最多保留 maxStale。
+// 远程节点和数据源都不可用时返回这些旧值, 返回的 ByteView.Stale 为 true。
+// maxBytes <= 0 时不使用, 默认不使用。
+func WithStaleIfError(maxBytes int64, maxStale time.Duration) GroupOption {
+	return func(g *Group) {
+		g.graveyardBytes = maxBytes
+		g.maxStale = maxStale
+	}
+}
+
+// WithPeerTimeout 设置等待负责的节点的最长时间, 超时或者节点不可用时回退到本地载入。
+// 负责的节点返回载入错误时不会回退。默认为2s。
+func WithPeerTimeout(timeout time.Duration) GroupOption {
+	return func(g *Group) {
+		if timeout > 0 {
+			g.peerTimeout = timeout
+		}
+	}
+}
+
+// WithLoadTimeout 设置一次载入(访问远程节点和数据源)的最长时间。
+// 载入由同一个key的所有调用方共享, 不会因为某一个调用方取消而停止。默认为5s。
+func WithLoadTimeout(timeout time.Duration) GroupOption {
+	return func(g *Group) {
+		if timeout > 0 {
+			g.loadTimeout = timeout
+		}
+	}
+}
+
+// WithReplicas 每个key保存在哈希环上连续的n个节点上, 需要 PeerPicker 实现 ReplicaPicker。
+// 载入时依次尝试每个副本, 都没有响应时才回退到本地; 副本从数据源载入后写入其他副本;
+// Set 和 Remove 发送给所有副本。默认为1, 不复制。
+func WithReplicas(n int) GroupOption {
+	return func(g *Group) {
+		if n > 0 {
+			g.replicas = n
+		}
+	}
+}
+
+// WithHedging 开启对冲请求: 负责的节点超过最近请求延迟的 percentile 分位数(不小于 minDelay)还没有返回时,
+// 向下一个副本发送同样的请求, 没有副本时从本地载入, 使用先返回的结果并取消另一个。
+// 对冲请求的数量不超过请求数量的 budget 比例, 例如 WithHedging(0.95, 10*time.Millisecond, 0.05)。
+func WithHedging(percentile float64, minDelay time.Duration, budget float64) GroupOption {
+	return func(g *Group) {
+		if percentile <= 0 || percentile > 1 || budget <= 0 {
+			return
+		}
+		g.hedge = newHedger(percentile, minDelay, budget)
+	}
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mini-cache/view"
+	"time"
+)
+
+// 负责的节点处理其他节点的请求, 整个集群中每个key同时只有一次载入
+
+/*
+	节点A ----Get----> 负责的节点B ----> 本地缓存 ----> singleflight ----> 数据源
+	节点C ----Get----> 负责的节点B --------------------↗ (共用同一次载入)
+
+	B 只从自己的数据源载入, 不会再转发给其他节点, 即使B和A的节点列表暂时不一致也不会来回转发;
+	载入与请求方的取消无关, 请求方超时之后载入仍然继续, 结果写入B的缓存。
+	A 和 C 只在B没有响应时回退到本地载入, 最多等待 peerTimeout; B 载入失败时不会回退,
+	否则同一个数据源会被所有节点再访问一次。
+*/
+
+// 负责的节点处理了请求, 但是从数据源载入失败
+var errOwnerLoad = errors.New("owner failed to load")
+
+const (
+	// 默认等待负责的节点的时间, 超时之后回退到本地载入
+	defaultPeerTimeout = 2 * time.Second
+	// 默认的载入超时时间, 与调用方无关
+	defaultLoadTimeout = 5 * time.Second
+)
+
+// 包装负责的节点返回的载入错误
+func ownerLoadError(msg string) error {
+	return fmt.Errorf("%w: %s", errOwnerLoad, msg)
+}
+
+// 处理其他节点的Get请求, 只在本地查找和载入
+func (g *Group) getForPeer(ctx context.Context, key string) (view.ByteView, error) {
+	if key == "" {
+		return view.ByteView{}, errors.New("key is required")
+	}
+	g.Stats.Gets.Add(1)
+	if v, ok := g.lookupCache(key); ok {
+		return v, nil
+	}
+	if g.lookupNegative(key) {
+		return view.ByteView{}, notFound(key)
+	}
+	g.Stats.Loads.Add(1)
+	return g.loadForPeer(ctx, key)
+}
+
+// 从数据源载入, ctx 只控制等待的时间, 请求方取消之后载入仍然继续
+func (g *Group) loadForPeer(ctx context.Context, key string) (view.ByteView, error) {
+	type result struct {
+		v   view.ByteView
+		err error
+	}
+	// 由负责的节点自己等待载入结束, 所有请求方都离开之后载入也不会被取消
+	ch := make(chan result, 1)
+	go func() {
+		v, err := g.loadShared(context.Background(), key, func(ctx context.Context) (interface{}, error) {
+			g.Stats.LoadsDeduped.Add(1)
+			return g.getFromLocalDB(ctx, key)
+		})
+		ch <- result{v, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		return view.ByteView{}, ctx.Err()
+	}
+}
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"context"
+
 	pb "mini-cache/proto"
 )
 
@@ -10,8 +12,32 @@ type PeerPicker interface {
 	PickPeer(key string) (peer PeerServer, ok bool)
 }
 
+// OwnerPicker 为写入选择负责key的节点, 见 Group.Set 和 Group.Remove; 没有实现时使用 PickPeer 和 PickReplicas。
+// 与读取不同, 不会因为有界负载溢出到其他节点, 也不会跳过熔断的节点,
+// 否则写入和删除到达不了负责的节点, 之后的读取仍然得到旧值。
+type OwnerPicker interface {
+	PeerPicker
+	// 返回负责key的节点, 本节点负责时返回false
+	PickOwner(key string) (peer PeerServer, ok bool)
+	// 按照优先级返回最多n个副本所在的节点, 本节点为nil
+	PickOwnerReplicas(key string, n int) []PeerServer
+}
+
+// ReplicaPicker 可以为key选择多个副本所在的节点, 见 WithReplicas
+type ReplicaPicker interface {
+	PeerPicker
+	// 按照优先级返回最多n个不同的节点, 第一个与 PickPeer 负责的节点相同, 本节点为nil
+	PickReplicas(key string, n int) []PeerServer
+}
+
 // PeerServer is the interface that must be implemented by a peer.
 type PeerServer interface {
 	// Get(group string, key string) ([]byte, error)
-	Get(in *pb.Request, out *pb.Response) error
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	// Set 写入一个key, 由对方节点直接保存在本地
+	Set(ctx context.Context, in *pb.SetRequest, out *pb.Ack) error
+	// Remove 删除对方节点本地保存的key
+	Remove(ctx context.Context, in *pb.Request, out *pb.Ack) error
+	// GetMany 批量读取, 每个key的结果和错误放在 out.Entries 中
+	GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
 }
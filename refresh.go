@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	concurrentcache "mini-cache/concurrent-cache"
+	"time"
+)
+
+// 后台刷新即将过期或者已经软过期的数据
+
+/*
+	写入 ----------------> 提前刷新 ----------------> 软过期 ----------------> 硬过期
+	     正常返回             返回并在后台刷新          返回旧值并在后台刷新       未命中
+	                        (WithRefreshAhead)     (WithStaleWhileRevalidate)
+*/
+
+// 后台刷新的超时时间
+const defaultRefreshTimeout = 5 * time.Second
+
+// 判断命中的数据是否需要在后台刷新
+func (g *Group) needsRefresh(item concurrentcache.Item, now time.Time) bool {
+	if item.Stale(now) {
+		g.Stats.StaleHits.Add(1)
+		return true
+	}
+	if g.refreshAhead <= 0 {
+		return false
+	}
+	deadline := item.Refresh
+	if deadline.IsZero() {
+		deadline = item.Expire
+	}
+	if deadline.IsZero() {
+		return false
+	}
+	lifetime := deadline.Sub(item.Added)
+	return now.After(item.Added.Add(time.Duration(float64(lifetime) * g.refreshAhead)))
+}
+
+// 在后台重新获取key, 同一个key同时只有一个刷新, 并且与正在进行的载入共用 singleflight
+func (g *Group) refresh(key string) {
+	if _, loaded := g.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	g.Stats.Refreshes.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		// 与触发刷新的请求无关, 请求结束之后仍然继续
+		ctx, cancel := context.WithTimeout(context.Background(), defaultRefreshTimeout)
+		defer cancel()
+		start := time.Now()
+		v, err := g.loadShared(ctx, key, func(ctx context.Context) (interface{}, error) {
+			return g.fetch(ctx, key)
+		})
+		if err == nil {
+			// 从远程节点获取的值只会写入热点缓存, 这里替换本地缓存中的旧值;
+			// 本地载入时已经写入, 刷新期间被替换或删除时也不再写入
+			if item, ok := g.coreCache.GetItem(key); ok && item.Added.Before(start) && !v.Stale {
+				g.populateCache(key, v, 0)
+			}
+			return
+		}
+		g.Stats.RefreshErrors.Add(1)
+		// 数据源中已经不存在, 不再返回旧值
+		if errors.Is(err, ErrNotFound) {
+			g.coreCache.Remove(key)
+			return
+		}
+		log.Println("[GeeCache] Failed to refresh", key, err)
+	}()
+}
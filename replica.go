@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	pb "mini-cache/proto"
+	"mini-cache/view"
+	"strings"
+)
+
+// 副本: 每个key保存在哈希环上连续的 replicas 个节点上
+
+/*
+	读取: 第一个副本 --没有响应--> 第二个副本 --没有响应--> ... --> 本地载入
+	      本节点是第一个副本时直接从数据源载入
+	载入: 副本从数据源载入之后, 异步写入其他副本
+	写入: Set 和 Remove 发送给所有副本
+	第一个副本故障时, 请求由第二个副本处理, 而不是所有节点同时访问数据源。
+*/
+
+// 没有可以写入的副本
+var errNoReplicas = errors.New("no replica for the key")
+
+// 返回key的所有副本, 本节点为nil; 没有开启副本或者 PeerPicker 不支持时返回false
+func (g *Group) pickReplicas(key string) ([]PeerServer, bool) {
+	if g.replicas <= 1 {
+		return nil, false
+	}
+	picker, ok := g.peerPicker.(ReplicaPicker)
+	if !ok {
+		return nil, false
+	}
+	return picker.PickReplicas(key, g.replicas), true
+}
+
+// 返回写入和删除时的所有副本, 与 pickReplicas 不同, 不会跳过熔断的节点
+func (g *Group) pickWriteReplicas(key string) ([]PeerServer, bool) {
+	if picker, ok := g.peerPicker.(OwnerPicker); ok && g.replicas > 1 {
+		return picker.PickOwnerReplicas(key, g.replicas), true
+	}
+	return g.pickReplicas(key)
+}
+
+// 返回载入时需要依次尝试的远程节点, 为空时从本地载入。
+// 没有开启副本时为负责的节点; 开启副本时为其他副本, 本节点是第一个副本时为空
+func (g *Group) pickOwners(key string) []PeerServer {
+	replicas, ok := g.pickReplicas(key)
+	if !ok {
+		if peer, ok := g.pickPeer(key); ok {
+			return []PeerServer{peer}
+		}
+		return nil
+	}
+	if len(replicas) == 0 || replicas[0] == nil {
+		return nil
+	}
+	peers := make([]PeerServer, 0, len(replicas))
+	for _, peer := range replicas {
+		if peer != nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// 本节点是key的副本时, 把从数据源载入的值异步写入其他副本, 其他副本使用默认过期时间
+func (g *Group) fillReplicas(key string, value view.ByteView) {
+	replicas, ok := g.pickReplicas(key)
+	if !ok || !isReplica(replicas) {
+		return
+	}
+	b := value.ByteSlice()
+	for _, peer := range replicas {
+		if peer == nil {
+			continue
+		}
+		go func(peer PeerServer) {
+			ctx, cancel := context.WithTimeout(context.Background(), g.peerTimeout)
+			defer cancel()
+			req := &pb.SetRequest{
+				Group: g.name,
+				Key:   key,
+				Value: b,
+			}
+			if err := peer.Set(ctx, req, &pb.Ack{}); err != nil {
+				log.Println("[GeeCache] Failed to fill replica", err)
+			}
+		}(peer)
+	}
+}
+
+// 写入所有副本, 本节点不是副本时清除本地的副本。
+// 返回所有副本的错误, 没有副本时也返回错误, 否则写入会被悄悄丢弃
+func (g *Group) setReplicas(ctx context.Context, replicas []PeerServer, key string, value []byte) error {
+	if !isReplica(replicas) {
+		defer g.removeLocally(key)
+	}
+	var errs replicaErrors
+	for _, peer := range replicas {
+		if peer == nil {
+			g.setLocally(key, value)
+			continue
+		}
+		req := &pb.SetRequest{
+			Group: g.name,
+			Key:   key,
+			Value: value,
+		}
+		if err := peer.Set(ctx, req, &pb.Ack{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err(len(replicas))
+}
+
+// 从所有副本删除, 返回所有副本的错误, 没有副本时也返回错误
+func (g *Group) removeReplicas(ctx context.Context, replicas []PeerServer, key string) error {
+	var errs replicaErrors
+	for _, peer := range replicas {
+		if peer == nil {
+			continue
+		}
+		req := &pb.Request{
+			Group: g.name,
+			Key:   key,
+		}
+		if err := peer.Remove(ctx, req, &pb.Ack{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err(len(replicas))
+}
+
+// 写入多个副本时的所有错误, errors.Is 和 errors.As 对其中任意一个成立即可
+type replicaErrors []error
+
+func (e replicaErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e replicaErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e replicaErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// 写入 n 个副本之后的结果, 没有错误时为nil
+func (e replicaErrors) err(n int) error {
+	switch {
+	case n == 0:
+		return errNoReplicas
+	case len(e) == 0:
+		return nil
+	}
+	return e
+}
+
+// 本节点是否为副本之一
+func isReplica(replicas []PeerServer) bool {
+	for _, peer := range replicas {
+		if peer == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -1,23 +1,51 @@
 package singleflight
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
-// 存放要返回的数据和WaitGroup
+// ErrGoexit fn 调用了 runtime.Goexit, 没有返回结果
+var ErrGoexit = errors.New("runtime.Goexit was called")
+
+// PanicError fn panic 时返回给等待者的错误, 保存 panic 的值和 fn 的调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.Value, p.Stack)
+}
+
+// 存放要返回的数据和结束信号
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	// fn 执行结束后关闭, 等待者可以同时监听 ctx 的取消
+	done chan struct{}
+	val  interface{}
+	err  error
 
+	// 等待同一个结果的其他调用者数量, 大于0时结果是共享的
+	dups int
+	// 仍在等待结果的调用者数量, DoContext 的调用者取消后减一
+	waiters int
+	// 取消 DoContext 传给 fn 的 ctx, 由 Do 和 DoChan 发起时为nil
+	cancel context.CancelFunc
 	// 异步调用的通道
 	chans []chan<- Result
 }
 
 // 传递异步调用的结果
 type Result struct {
-	val interface{}
-	err error
+	Val interface{}
+	Err error
+	// 结果是否同时返回给了多个调用者
+	Shared bool
 }
 
 type Group struct {
@@ -25,67 +53,168 @@ type Group struct {
 	m  map[string]*call
 }
 
-// Do 的作用就是，针对相同的 key，无论 Do 被调用多少次，函数 fn 都只会被调用一次，等待 fn 调用结束了，返回返回值或错误。
-// 同步调用
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
-	g.mu.Lock()
+// 返回key对应的调用, 没有时创建一个, 第二个返回值表示是否新创建
+func (g *Group) join(key string) (*call, bool) {
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
-		// 正在被其他线程调用，等待结果
-		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		c.dups++
+		c.waiters++
+		return c, false
 	}
-	// 没有调用过
-	c := new(call)
-	// 线程开始, 加锁, 表示正在调用
-	c.wg.Add(1)
-	// 添加k-v
+	c := &call{done: make(chan struct{}), waiters: 1}
 	g.m[key] = c
+	return c, true
+}
+
+// Do 的作用就是，针对相同的 key，无论 Do 被调用多少次，函数 fn 都只会被调用一次，等待 fn 调用结束了，返回返回值或错误。
+// 同步调用, fn 在第一个调用者的 goroutine 中执行。
+// fn panic 时所有调用者都会 panic; fn 调用 runtime.Goexit 时所有调用者都会退出。
+func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	c, first := g.join(key)
 	g.mu.Unlock()
 
-	// 调用fn
-	c.val, c.err = fn()
-	// 线程执行结束, 释放锁. 其他等待线程可以继续执行, 获取当前线程的结果. 这样可以防止重复调用
-	c.wg.Done()
+	if first {
+		g.doCall(c, key, fn)
+	} else {
+		// 正在被其他线程调用，等待结果
+		<-c.done
+	}
+	v, err := c.wait()
+	if err == ErrGoexit {
+		runtime.Goexit()
+	}
+	return v, err
+}
 
-	// 立刻删除调用记录, 防止出现读取旧数据的情况.
+// DoContext 与 Do 相同, 但是每个调用者在等待结果时都会监听自己的 ctx,
+// ctx 被取消后立即返回 ctx.Err(), 不再等待 fn 结束。
+// fn 在新的 goroutine 中执行, 结果由所有调用者共享, 因此 fn 收到的 ctx 只保留第一个调用者 ctx 中的值,
+// 不会因为某一个调用者取消或超时而取消; 所有调用者都离开之后才会取消, 之后的调用重新执行 fn。
+// fn panic 时所有等待的调用者都会 panic; fn 调用 runtime.Goexit 时返回 ErrGoexit。
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
 	g.mu.Lock()
-	delete(g.m, key)
+	c, first := g.join(key)
+	if first {
+		// 没有调用过, 由当前调用者发起
+		fctx, cancel := context.WithCancel(detached{ctx})
+		c.cancel = cancel
+		go g.doCall(c, key, func() (interface{}, error) {
+			defer cancel()
+			return fn(fctx)
+		})
+	}
 	g.mu.Unlock()
 
-	return c.val, c.err
+	select {
+	case <-c.done:
+		return c.wait()
+	case <-ctx.Done():
+		g.leave(c, key)
+		return nil, ctx.Err()
+	}
 }
 
-// 异步调用
-func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
-	ch := make(chan Result, 1)
+// 调用者不再等待, 最后一个调用者离开时取消 fn
+func (g *Group) leave(c *call, key string) {
 	g.mu.Lock()
-	// 不存在字典
-	if g.m == nil {
-		g.m = make(map[string]*call)
+	defer g.mu.Unlock()
+	c.waiters--
+	if c.waiters > 0 || c.cancel == nil {
+		return
 	}
-	// 正在调用
-	if c, ok := g.m[key]; ok {
-		c.chans = append(c.chans, ch)
-		g.mu.Unlock()
-		return ch   // 调用结束, 返回值
+	// 已经取消的调用不能再被加入, 之后的调用者重新执行 fn
+	if g.m[key] == c {
+		delete(g.m, key)
 	}
-	// 没有被调用过
-	c := new(call) // 新增call
-	g.m[key] = c
+	c.cancel()
+}
+
+// 保留父 ctx 中的值, 但是没有截止时间, 也不会被取消
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detached) Done() <-chan struct{}               { return nil }
+func (detached) Err() error                          { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// DoChan 与 Do 相同, 但是立即返回, 结果通过通道传递。
+// fn 在新的 goroutine 中执行; fn panic 时 Result.Err 为 *PanicError, 调用 runtime.Goexit 时为 ErrGoexit。
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	c, first := g.join(key)
+	c.chans = append(c.chans, ch)
 	g.mu.Unlock()
 
-	c.val, c.err = fn() // 执行fn, 得到值
+	if first {
+		go g.doCall(c, key, fn)
+	}
+	return ch
+}
 
+// Forget 让之后对key的调用重新执行fn, 而不是等待正在执行的fn。
+// 正在等待的调用者仍然得到正在执行的fn的结果。
+func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.m, key)
 	g.mu.Unlock()
-	// 正在监听的每个通道都发送一个信息
-	for _, ch := range c.chans {
-		ch <- Result{c.val, c.err}
+}
+
+// 执行fn, 记录结果并通知所有等待者。
+// fn 的 panic 会被捕获并保存为 *PanicError, 由等待者决定是否继续 panic。
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
+
+	// 正常返回, panic 和 runtime.Goexit 都会执行
+	defer func() {
+		if !normalReturn && !recovered {
+			// 既没有返回也没有 panic, 只能是 runtime.Goexit
+			c.val, c.err = nil, ErrGoexit
+		}
+
+		g.mu.Lock()
+		// 立刻删除调用记录, 防止出现读取旧数据的情况. Forget 之后可能已经是新的调用
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		chans, shared := c.chans, c.dups > 0
+		g.mu.Unlock()
+
+		// 执行结束, 关闭通道. 其他等待线程可以继续执行, 获取当前线程的结果
+		close(c.done)
+		// 正在监听的每个通道都发送一个信息, 通道有缓冲, 不会阻塞
+		for _, ch := range chans {
+			ch <- Result{Val: c.val, Err: c.err, Shared: shared}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					c.val, c.err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
 	}
-	return ch
+}
+
+// 在 done 关闭之后读取结果, fn panic 时在当前 goroutine 中继续 panic
+func (c *call) wait() (interface{}, error) {
+	if e, ok := c.err.(*PanicError); ok {
+		panic(e)
+	}
+	return c.val, c.err
 }
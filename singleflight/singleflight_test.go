@@ -0,0 +1,335 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Fatalf("Do = %v, %v", v, err)
+	}
+
+	someErr := errors.New("some error")
+	if _, err = g.Do("key", func() (interface{}, error) { return nil, someErr }); err != someErr {
+		t.Fatalf("Do error = %v; want %v", err, someErr)
+	}
+}
+
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.Do("key", fn); v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+		}()
+	}
+	// 等待所有调用者进入 Do
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("fn called %d times; want 1", calls)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	var calls int32
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	// fn 没有结束之前 DoChan 已经返回
+	ch1 := g.DoChan("key", fn)
+	ch2 := g.DoChan("key", fn)
+	select {
+	case <-ch1:
+		t.Fatalf("DoChan should not wait for fn")
+	default:
+	}
+	close(release)
+
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		select {
+		case res := <-ch:
+			if res.Val != "bar" || res.Err != nil || !res.Shared {
+				t.Fatalf("unexpected result %+v", res)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("DoChan result timeout")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times; want 1", calls)
+	}
+
+	res := <-g.DoChan("key", func() (interface{}, error) { return "baz", nil })
+	if res.Val != "baz" || res.Shared {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+
+	// Forget 之后的调用重新执行
+	g.Forget("key")
+	second := g.DoChan("key", func() (interface{}, error) { return 2, nil })
+	if res := <-second; res.Val != 2 {
+		t.Fatalf("call after Forget should run again, got %v", res.Val)
+	}
+
+	// 原来的调用仍然返回自己的结果, 并且不会删除新的调用
+	third := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 3, nil
+	})
+	close(release)
+	if res := <-first; res.Val != 1 {
+		t.Fatalf("forgotten call should still return its result, got %v", res.Val)
+	}
+	if res := <-third; res.Val != 3 {
+		t.Fatalf("expect 3, got %v", res.Val)
+	}
+}
+
+func TestPanicDo(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("invalid memory address or nil pointer dereference")
+	}
+
+	const n = 5
+	var panics int32
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					if _, ok := r.(*PanicError); !ok {
+						t.Errorf("expect *PanicError, got %T", r)
+					}
+					atomic.AddInt32(&panics, 1)
+				}
+			}()
+			g.Do("key", fn)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	// 所有调用者都 panic, 不会有调用者一直等待
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Do hangs after a panic")
+	}
+	if panics != n {
+		t.Fatalf("expect %d panics, got %d", n, panics)
+	}
+}
+
+func TestPanicDoChanAndContext(t *testing.T) {
+	var g Group
+	res := <-g.DoChan("key", func() (interface{}, error) {
+		panic("boom")
+	})
+	var pe *PanicError
+	if !errors.As(res.Err, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Fatalf("expect *PanicError, got %v", res.Err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("DoContext should panic")
+			}
+		}()
+		g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			panic("boom")
+		})
+	}()
+}
+
+func TestGoexit(t *testing.T) {
+	var g Group
+	// Do 的调用者和 fn 在同一个 goroutine 中, 一起退出
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Do("key", func() (interface{}, error) {
+			runtime.Goexit()
+			return nil, nil
+		})
+		t.Errorf("Do should not return after runtime.Goexit")
+	}()
+	<-done
+
+	// 其他调用方式返回 ErrGoexit
+	res := <-g.DoChan("key", func() (interface{}, error) {
+		runtime.Goexit()
+		return nil, nil
+	})
+	if res.Err != ErrGoexit {
+		t.Fatalf("expect ErrGoexit, got %v", res.Err)
+	}
+	_, err := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		runtime.Goexit()
+		return nil, nil
+	})
+	if err != ErrGoexit {
+		t.Fatalf("expect ErrGoexit, got %v", err)
+	}
+
+	// 之后的调用不受影响
+	if v, err := g.Do("key", func() (interface{}, error) { return "bar", nil }); v != "bar" || err != nil {
+		t.Fatalf("Do = %v, %v", v, err)
+	}
+}
+
+func TestDoContextCancel(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := g.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "bar", nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
+
+func TestDoContextSharedCancel(t *testing.T) {
+	var g Group
+	started, release := make(chan struct{}), make(chan struct{})
+
+	// 第一个调用者取消, 不影响其他等待者, fn 的 ctx 也不会被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := g.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			select {
+			case <-release:
+				return "bar", nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+		errc <- err
+	}()
+	<-started
+	res := make(chan interface{})
+	go func() {
+		v, err := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			return "dup", nil
+		})
+		if err != nil {
+			v = err
+		}
+		res <- v
+	}()
+	// 等待第二个调用方加入
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+	close(release)
+	if v := <-res; v != "bar" {
+		t.Fatalf("the other waiter should get the shared result, got %v", v)
+	}
+}
+
+func TestDoContextAllCanceled(t *testing.T) {
+	var g Group
+	started, canceled := make(chan struct{}), make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}
+
+	// 所有调用者都取消之后, fn 的 ctx 被取消
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errc := make(chan error, 2)
+	go func() {
+		_, err := g.DoContext(ctx1, "key", fn)
+		errc <- err
+	}()
+	<-started
+	go func() {
+		_, err := g.DoContext(ctx2, "key", fn)
+		errc <- err
+	}()
+	// 等待第二个调用方加入
+	time.Sleep(10 * time.Millisecond)
+	cancel1()
+	<-errc
+	select {
+	case <-canceled:
+		t.Fatalf("fn should not be canceled while a caller is waiting")
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel2()
+	<-errc
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("fn should be canceled after every caller has left")
+	}
+
+	// 之后的调用重新执行 fn, 不会得到取消的结果
+	v, err := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		return "bar", nil
+	})
+	if err != nil || v != "bar" {
+		t.Fatalf("expect a new call, got %v, %v", v, err)
+	}
+}
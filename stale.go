@@ -0,0 +1,24 @@
+package cache
+
+import "mini-cache/view"
+
+// stale-if-error: 主缓存中被淘汰或者过期的数据放入 graveyard,
+// 远程节点和数据源都失败时返回其中的旧值, 度过数据源短暂的不可用。
+
+// 主缓存的 OnEvicted 回调
+func (g *Group) bury(key string, value view.ByteView) {
+	g.graveyard.AddWithTTL(key, value, g.maxStale)
+}
+
+// 查找旧值, 返回的值标记为 Stale
+func (g *Group) lookupGraveyard(key string) (view.ByteView, bool) {
+	if g.graveyard == nil {
+		return view.ByteView{}, false
+	}
+	v, ok := g.graveyard.Get(key)
+	if !ok {
+		return view.ByteView{}, false
+	}
+	g.Stats.StaleIfErrorHits.Add(1)
+	return view.ByteView{B: v.B, Stale: true}, true
+}
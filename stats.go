@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"io"
+	concurrentcache "mini-cache/concurrent-cache"
+	"mini-cache/metrics"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// 统计缓存的运行情况, 以Prometheus文本格式输出
+
+// 暴露指标的路径
+const defaultMetricsPath = "/metrics"
+
+// AtomicInt 并发安全的计数器
+type AtomicInt int64
+
+// Add 原子地增加n
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 原子地读取当前值
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats Group的统计数据
+type Stats struct {
+	Gets             AtomicInt // 所有的Get请求, 包括来自其他节点的请求
+	CacheHits        AtomicInt // 命中本地缓存, 包括热点缓存
+	HotCacheHits     AtomicInt // 命中热点缓存
+	NegativeHits     AtomicInt // 命中负缓存, 直接返回 ErrNotFound
+	StaleHits        AtomicInt // 命中软过期的数据, 返回旧值
+	Refreshes        AtomicInt // 后台刷新的次数
+	RefreshErrors    AtomicInt // 后台刷新失败
+	StaleIfErrorHits AtomicInt // 数据源不可用时返回旧值
+	CacheMisses      AtomicInt // 没有命中本地缓存
+	Loads            AtomicInt // 需要载入数据的请求 (gets - cacheHits)
+	LoadsDeduped     AtomicInt // singleflight合并之后实际执行的载入
+	PeerLoads        AtomicInt // 从远程节点获取成功
+	PeerErrors       AtomicInt // 从远程节点获取失败
+	Hedges           AtomicInt // 负责的节点响应太慢时发出的对冲请求
+	HedgeWins        AtomicInt // 对冲请求先返回的次数
+	LocalLoads       AtomicInt // 从数据源获取成功
+	LocalLoadErrs    AtomicInt // 从数据源获取失败
+	BatchLoads       AtomicInt // 调用 BatchGettr.GetMany 的次数
+	ServerRequests   AtomicInt // 收到其他节点的请求
+	ServerRejected   AtomicInt // 并发数量达到上限, 拒绝的其他节点的请求
+}
+
+// CacheStats 本地缓存的使用情况
+type CacheStats struct {
+	Items uint64 // 缓存的key数量
+	Bytes uint64 // 使用的内存
+}
+
+// CacheType 本地缓存的类型
+type CacheType int
+
+const (
+	// MainCache 存放本节点负责的key
+	MainCache CacheType = iota + 1
+	// HotCache 存放从远程节点获取的热点key
+	HotCache
+	// NegativeCache 存放数据源中不存在的key, 每个key占用一个字节
+	NegativeCache
+	// StaleCache 存放最近被淘汰或者过期的数据
+	StaleCache
+)
+
+func (t CacheType) String() string {
+	switch t {
+	case HotCache:
+		return "hot"
+	case NegativeCache:
+		return "negative"
+	case StaleCache:
+		return "stale"
+	default:
+		return "main"
+	}
+}
+
+// CacheStats 返回本地缓存的使用情况, 没有使用热点缓存或负缓存时统计为零值
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	var c *concurrentcache.ConcurrentCache
+	switch which {
+	case HotCache:
+		c = g.hotCache
+	case NegativeCache:
+		c = g.negCache
+	case StaleCache:
+		c = g.graveyard
+	default:
+		c = g.coreCache
+	}
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Items: c.KeyCount(),
+		Bytes: c.UsedMemorySize(),
+	}
+}
+
+// Group的计数器, 按输出顺序排列
+var groupCounters = []struct {
+	name, help string
+	value      func(s *Stats) *AtomicInt
+}{
+	{"minicache_gets_total", "Get requests, including requests from peers.", func(s *Stats) *AtomicInt { return &s.Gets }},
+	{"minicache_cache_hits_total", "Get requests served from the local cache.", func(s *Stats) *AtomicInt { return &s.CacheHits }},
+	{"minicache_hot_cache_hits_total", "Get requests served from the hot cache.", func(s *Stats) *AtomicInt { return &s.HotCacheHits }},
+	{"minicache_negative_hits_total", "Get requests answered from the negative cache.", func(s *Stats) *AtomicInt { return &s.NegativeHits }},
+	{"minicache_stale_hits_total", "Get requests served with a stale value.", func(s *Stats) *AtomicInt { return &s.StaleHits }},
+	{"minicache_refreshes_total", "Background refreshes started.", func(s *Stats) *AtomicInt { return &s.Refreshes }},
+	{"minicache_refresh_errors_total", "Background refreshes that failed.", func(s *Stats) *AtomicInt { return &s.RefreshErrors }},
+	{"minicache_stale_if_error_hits_total", "Loads answered with an evicted or expired value after an error.", func(s *Stats) *AtomicInt { return &s.StaleIfErrorHits }},
+	{"minicache_cache_misses_total", "Get requests missing the local cache.", func(s *Stats) *AtomicInt { return &s.CacheMisses }},
+	{"minicache_loads_total", "Get requests that needed a load.", func(s *Stats) *AtomicInt { return &s.Loads }},
+	{"minicache_loads_deduped_total", "Loads left after singleflight deduplication.", func(s *Stats) *AtomicInt { return &s.LoadsDeduped }},
+	{"minicache_peer_loads_total", "Values fetched from a peer.", func(s *Stats) *AtomicInt { return &s.PeerLoads }},
+	{"minicache_peer_errors_total", "Failed fetches from a peer.", func(s *Stats) *AtomicInt { return &s.PeerErrors }},
+	{"minicache_hedges_total", "Hedged requests sent after a slow peer.", func(s *Stats) *AtomicInt { return &s.Hedges }},
+	{"minicache_hedge_wins_total", "Hedged requests answering first.", func(s *Stats) *AtomicInt { return &s.HedgeWins }},
+	{"minicache_local_loads_total", "Values loaded from the data source.", func(s *Stats) *AtomicInt { return &s.LocalLoads }},
+	{"minicache_local_load_errors_total", "Failed loads from the data source.", func(s *Stats) *AtomicInt { return &s.LocalLoadErrs }},
+	{"minicache_batch_loads_total", "Calls to BatchGettr.GetMany.", func(s *Stats) *AtomicInt { return &s.BatchLoads }},
+	{"minicache_server_requests_total", "Requests received from peers.", func(s *Stats) *AtomicInt { return &s.ServerRequests }},
+	{"minicache_server_rejected_total", "Requests from peers rejected by admission control.", func(s *Stats) *AtomicInt { return &s.ServerRejected }},
+}
+
+// WriteMetrics 以Prometheus文本格式输出所有Group的指标
+func WriteMetrics(w io.Writer) {
+	mu.Lock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := make([]*Group, len(names))
+	for i, name := range names {
+		all[i] = groups[name]
+	}
+	mu.Unlock()
+
+	for _, c := range groupCounters {
+		metrics.WriteHeader(w, c.name, c.help, "counter")
+		for _, g := range all {
+			metrics.WriteSample(w, c.name, metrics.Labels("group", g.name), float64(c.value(&g.Stats).Get()))
+		}
+	}
+
+	caches := []CacheType{MainCache, HotCache, NegativeCache, StaleCache}
+	stats := make([][]CacheStats, len(all))
+	for i, g := range all {
+		for _, which := range caches {
+			stats[i] = append(stats[i], g.CacheStats(which))
+		}
+	}
+	metrics.WriteHeader(w, "minicache_cache_items", "Keys in the local cache.", "gauge")
+	for i, g := range all {
+		for j, which := range caches {
+			metrics.WriteSample(w, "minicache_cache_items", metrics.Labels("group", g.name, "cache", which.String()), float64(stats[i][j].Items))
+		}
+	}
+	metrics.WriteHeader(w, "minicache_cache_bytes", "Bytes used by the local cache.", "gauge")
+	for i, g := range all {
+		for j, which := range caches {
+			metrics.WriteSample(w, "minicache_cache_bytes", metrics.Labels("group", g.name, "cache", which.String()), float64(stats[i][j].Bytes))
+		}
+	}
+
+}
+
+// 输出访问远程节点的延迟, latency keyed by 节点地址
+func writePeerLatency(w io.Writer, latency map[string]*metrics.Histogram) {
+	peers := make([]string, 0, len(latency))
+	for peer := range latency {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	metrics.WriteHeader(w, "minicache_peer_request_duration_seconds", "Latency of requests sent to peers.", "histogram")
+	for _, peer := range peers {
+		latency[peer].Write(w, "minicache_peer_request_duration_seconds", metrics.Labels("peer", peer))
+	}
+}
+
+// MetricsHandler 返回输出指标的 http.Handler。
+// 熔断器和远程节点的延迟属于各个 HttpServer, 只在 HttpServer 的 /metrics 上输出
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		WriteMetrics(w)
+	})
+}
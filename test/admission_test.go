@@ -0,0 +1,151 @@
+package cache_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+)
+
+// key 为 "block" 时等待 unblock 被关闭
+func blockingGroup(name string, started chan<- struct{}, unblock <-chan struct{}) *cache.Group {
+	return cache.NewGroup(name, 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			switch key {
+			case "block":
+				started <- struct{}{}
+				<-unblock
+			case "missing":
+				return nil, cache.ErrNotFound
+			case "broken":
+				return nil, errors.New("db down")
+			}
+			return []byte("db-" + key), nil
+		}))
+}
+
+// 返回状态码和 Retry-After, 请求失败时状态码为0
+func statusOf(method, url string) (int, string) {
+	req, _ := http.NewRequest(method, url, strings.NewReader("not a proto"))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, ""
+	}
+	res.Body.Close()
+	return res.StatusCode, res.Header.Get("Retry-After")
+}
+
+func TestAdmissionErrorPaths(t *testing.T) {
+	blockingGroup("admission-errors", make(chan struct{}), nil)
+	p := cache.NewHttpServer("http://self", cache.WithConcurrencyLimit(1, 0, 10*time.Millisecond))
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+	base := ts.URL + "/api/cache/"
+
+	// 每个错误的返回路径都要释放, 否则之后的请求都会被拒绝
+	for _, c := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPost, "admission-errors", http.StatusBadRequest},
+		{http.MethodGet, "no-such-group/Tom", http.StatusNotFound},
+		{http.MethodGet, "admission-errors/missing", http.StatusNotFound},
+		{http.MethodGet, "admission-errors/broken", http.StatusBadGateway},
+		{http.MethodGet, "admission-errors/broken", http.StatusBadGateway},
+	} {
+		if code, _ := statusOf(c.method, base+c.path); code != c.code {
+			t.Fatalf("%s %s: expect %d, got %d", c.method, c.path, c.code, code)
+		}
+	}
+	if code, _ := statusOf(http.MethodGet, base+"admission-errors/Tom"); code != http.StatusOK {
+		t.Fatalf("slots leaked on error paths, got %d", code)
+	}
+}
+
+func TestAdmissionShedding(t *testing.T) {
+	started, unblock := make(chan struct{}), make(chan struct{})
+	gee := blockingGroup("admission-shedding", started, unblock)
+	p := cache.NewHttpServer("http://self", cache.WithConcurrencyLimit(1, 1, 200*time.Millisecond))
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+	url := ts.URL + "/api/cache/admission-shedding/"
+
+	done := make(chan int)
+	go func() {
+		code, _ := statusOf(http.MethodGet, url+"block")
+		done <- code
+	}()
+	<-started
+
+	// 队列中的请求等待超时返回503, 队列已满时返回429
+	queued := make(chan string)
+	go func() {
+		code, retry := statusOf(http.MethodGet, url+"Tom")
+		queued <- fmt.Sprintf("%d %s", code, retry)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if code, retry := statusOf(http.MethodGet, url+"Jack"); code != http.StatusTooManyRequests || retry != "1" {
+		t.Fatalf("expect 429 with Retry-After, got %d %q", code, retry)
+	}
+	if r := <-queued; r != "503 1" {
+		t.Fatalf("expect 503 with Retry-After, got %q", r)
+	}
+	if n := gee.Stats.ServerRejected.Get(); n != 2 {
+		t.Fatalf("expect 2 rejected requests, got %d", n)
+	}
+
+	close(unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("the admitted request should succeed, got %d", code)
+	}
+	if code, _ := statusOf(http.MethodGet, url+"Sam"); code != http.StatusOK {
+		t.Fatalf("expect 200 after the slot is released, got %d", code)
+	}
+}
+
+func TestAdmissionPriority(t *testing.T) {
+	started, unblock := make(chan struct{}), make(chan struct{})
+	blockingGroup("admission-low", started, unblock)
+	blockingGroup("admission-high", started, unblock)
+	p := cache.NewHttpServer("http://self", cache.WithConcurrencyLimit(1, 1, 2*time.Second),
+		cache.WithGroupPriority("admission-high", 1))
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+	base := ts.URL + "/api/cache/"
+
+	done := make(chan int)
+	go func() {
+		code, _ := statusOf(http.MethodGet, base+"admission-low/block")
+		done <- code
+	}()
+	<-started
+
+	// 优先级高的请求挤掉队列中优先级低的请求
+	low := make(chan int)
+	go func() {
+		code, _ := statusOf(http.MethodGet, base+"admission-low/Tom")
+		low <- code
+	}()
+	time.Sleep(50 * time.Millisecond)
+	high := make(chan int)
+	go func() {
+		code, _ := statusOf(http.MethodGet, base+"admission-high/Tom")
+		high <- code
+	}()
+	if code := <-low; code != http.StatusTooManyRequests {
+		t.Fatalf("the low priority request should be shed, got %d", code)
+	}
+
+	close(unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("the admitted request should succeed, got %d", code)
+	}
+	if code := <-high; code != http.StatusOK {
+		t.Fatalf("the high priority request should be served, got %d", code)
+	}
+}
@@ -0,0 +1,122 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+)
+
+// 首字母小于 "m" 的key属于a, 其余的属于b, "local" 开头的属于本节点
+type splitPicker struct {
+	a, b *fakePeer
+}
+
+func (s splitPicker) PickPeer(key string) (cache.PeerServer, bool) {
+	switch {
+	case len(key) >= 5 && key[:5] == "local":
+		return nil, false
+	case key < "m":
+		return s.a, true
+	default:
+		return s.b, true
+	}
+}
+
+func TestGetManyLocal(t *testing.T) {
+	var mu sync.Mutex
+	loadCounts := make(map[string]int)
+	gee := cache.NewGroup("get-many", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			loadCounts[key]++
+			mu.Unlock()
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, errors.New("not exist")
+		}))
+	gee.Get("Tom")
+
+	keys := []string{"Tom", "Jack", "unknown", "Jack", ""}
+	values, errs := gee.GetMany(context.Background(), keys)
+	if len(values) != len(keys) || len(errs) != len(keys) {
+		t.Fatalf("results should match keys")
+	}
+	for i, expect := range []string{"630", "589", "", "589", ""} {
+		if values[i].String() != expect {
+			t.Errorf("key %q: expect %q, got %q", keys[i], expect, values[i].String())
+		}
+	}
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil || errs[4] == nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	// 重复的key只载入一次, 已经缓存的key不再载入
+	if loadCounts["Tom"] != 1 || loadCounts["Jack"] != 1 {
+		t.Fatalf("unexpected load counts %v", loadCounts)
+	}
+}
+
+func TestGetManyPeers(t *testing.T) {
+	a := &fakePeer{values: map[string][]byte{"apple": []byte("a1"), "banana": []byte("a2")}}
+	b := &fakePeer{values: map[string][]byte{"pear": []byte("b1")}}
+	gee := cache.NewGroup("get-many-peers", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithHotCache(0, 1))
+	gee.RegisterPeers(splitPicker{a: a, b: b})
+
+	keys := []string{"apple", "pear", "local-1", "banana", "plum"}
+	values, errs := gee.GetMany(context.Background(), keys)
+	for i, expect := range []string{"a1", "b1", "db-local-1", "a2"} {
+		if errs[i] != nil || values[i].String() != expect {
+			t.Errorf("key %q: expect %q, got %q (%v)", keys[i], expect, values[i].String(), errs[i])
+		}
+	}
+	// plum 在负责的节点载入失败, 不会回退到本地重复访问数据源
+	if errs[4] == nil || values[4].Len() != 0 {
+		t.Errorf("key plum: expect owner error, got %q (%v)", values[4].String(), errs[4])
+	}
+	// 每个节点只有一次批量请求
+	if a.batches != 1 || b.batches != 1 {
+		t.Fatalf("expect one batch per peer, got a=%d b=%d", a.batches, b.batches)
+	}
+	if gee.Stats.PeerLoads.Get() != 3 || gee.Stats.PeerErrors.Get() != 1 || gee.Stats.LocalLoads.Get() != 1 {
+		t.Fatalf("unexpected stats: peer loads %v, peer errors %v, local loads %v",
+			&gee.Stats.PeerLoads, &gee.Stats.PeerErrors, &gee.Stats.LocalLoads)
+	}
+}
+
+func TestHttpGetMany(t *testing.T) {
+	cache.NewGroup("http-get-many", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			if key == "unknown" {
+				return nil, errors.New("not exist")
+			}
+			return []byte("v-" + key), nil
+		}))
+	ts := httptest.NewServer(cache.NewHttpServer("http://owner"))
+	defer ts.Close()
+
+	client := cache.NewHttpServer("http://self")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("a key")
+
+	res := &pb.BatchResponse{}
+	req := &pb.BatchRequest{Group: "http-get-many", Keys: []string{"a key", "unknown", "a/b"}}
+	if err := peer.GetMany(context.Background(), req, res); err != nil {
+		t.Fatal(err)
+	}
+	e := res.GetEntries()
+	if len(e) != 3 || string(e[0].GetValue()) != "v-a key" || e[1].GetError() == "" || string(e[2].GetValue()) != "v-a/b" {
+		t.Fatalf("unexpected batch response %v", e)
+	}
+
+	if err := peer.GetMany(context.Background(), &pb.BatchRequest{Group: "no-such-group"}, &pb.BatchResponse{}); err == nil {
+		t.Fatalf("no such group should fail")
+	}
+}
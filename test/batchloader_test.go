@@ -0,0 +1,122 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+)
+
+// 同时实现 Gettr 和 BatchGettr 的数据源
+type batchDB struct {
+	mu      sync.Mutex
+	batches [][]string
+	fail    bool
+}
+
+func (d *batchDB) Get(key string) ([]byte, error) {
+	return nil, errors.New("Get should not be called")
+}
+
+func (d *batchDB) GetMany(keys []string) (map[string][]byte, error) {
+	d.mu.Lock()
+	d.batches = append(d.batches, keys)
+	d.mu.Unlock()
+	if d.fail {
+		return nil, errors.New("db down")
+	}
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if key != "unknown" {
+			values[key] = []byte("v-" + key)
+		}
+	}
+	return values, nil
+}
+
+func (d *batchDB) batchCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.batches)
+}
+
+func TestBatchGettr(t *testing.T) {
+	db := &batchDB{}
+	gee := cache.NewGroup("batch-gettr", 2<<10, db, cache.WithBatchWindow(20*time.Millisecond, 100))
+
+	// 并发的未命中合并为一次调用, 重复的key由singleflight合并
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i%10)
+			if v, err := gee.Get(key); err != nil || v.String() != "v-"+key {
+				t.Errorf("get %s failed: %v", key, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if db.batchCount() != 1 || len(db.batches[0]) != 10 {
+		t.Fatalf("expect one batch of 10 keys, got %v", db.batches)
+	}
+	if gee.Stats.BatchLoads.Get() != 1 {
+		t.Fatalf("expect 1 batch load, got %v", &gee.Stats.BatchLoads)
+	}
+
+	// GetMany 未命中的key同样合并, 没有返回的key视为失败
+	values, errs := gee.GetMany(context.Background(), []string{"key-1", "a", "b", "unknown"})
+	if values[0].String() != "v-key-1" || values[1].String() != "v-a" || values[2].String() != "v-b" || errs[3] == nil {
+		t.Fatalf("unexpected results %v %v", values, errs)
+	}
+	if db.batchCount() != 2 || len(db.batches[1]) != 3 {
+		t.Fatalf("expect a second batch of 3 keys, got %v", db.batches)
+	}
+}
+
+func TestBatchGettrMaxSize(t *testing.T) {
+	db := &batchDB{}
+	// 时间窗口较长, 达到最大数量时立即载入, 不等待窗口结束
+	gee := cache.NewGroup("batch-gettr-max", 2<<10, db, cache.WithBatchWindow(time.Second, 5))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := gee.Get(fmt.Sprintf("key-%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if time.Since(start) >= time.Second {
+		t.Fatalf("full batches should not wait for the window")
+	}
+	if db.batchCount() != 2 || len(db.batches[0]) != 5 || len(db.batches[1]) != 5 {
+		t.Fatalf("expect 2 batches of 5 keys, got %v", db.batches)
+	}
+}
+
+func TestBatchGettrError(t *testing.T) {
+	db := &batchDB{fail: true}
+	gee := cache.NewGroup("batch-gettr-error", 2<<10, db)
+
+	_, errs := gee.GetMany(context.Background(), []string{"a", "b"})
+	if errs[0] == nil || errs[1] == nil {
+		t.Fatalf("all keys in a failed batch should fail, got %v", errs)
+	}
+
+	// 等待中的调用方可以被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slow := cache.NewGroup("batch-gettr-cancel", 2<<10, db, cache.WithBatchWindow(time.Hour, 100))
+	if _, err := slow.GetContext(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package cache_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	cache "mini-cache"
+	consistenthash "mini-cache/consistent-hash"
+	pb "mini-cache/proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// 收到请求之后一直等待, 直到 release 被关闭
+func blockingNode(t *testing.T, received chan<- string, release <-chan struct{}) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- ts.URL
+		<-release
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("v")})
+		w.Write(body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestHttpBoundedLoad(t *testing.T) {
+	received := make(chan string)
+	release := make(chan struct{})
+	a := blockingNode(t, received, release)
+	b := blockingNode(t, received, release)
+
+	p := cache.NewHttpServer("http://self", cache.WithBoundedLoad(0.25))
+	p.Set(a.URL, b.URL)
+
+	// 同一个热点key的并发请求, 负责的节点达到上限后溢出到另一个节点
+	const n = 6
+	var wg sync.WaitGroup
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		peer, ok := p.PickPeer("hot")
+		if !ok {
+			t.Fatalf("PickPeer should return a peer")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer.Get(context.Background(), &pb.Request{Group: "g", Key: "hot"}, &pb.Response{})
+		}()
+		// 等待请求到达, 下一次选择时负载已经更新
+		counts[<-received]++
+	}
+	// 每个节点最多 ceil(1.25 * 6 / 2) = 4 个请求
+	if counts[a.URL] == 0 || counts[b.URL] == 0 || counts[a.URL] > 4 || counts[b.URL] > 4 {
+		t.Fatalf("hot key should overflow to the other peer, got %v", counts)
+	}
+	if loads := p.Loads(); loads[a.URL]+loads[b.URL] != n {
+		t.Fatalf("unexpected loads %v", loads)
+	}
+
+	// 请求完成之后负载归零
+	close(release)
+	wg.Wait()
+	if loads := p.Loads(); loads[a.URL] != 0 || loads[b.URL] != 0 {
+		t.Fatalf("loads should drop to zero, got %v", loads)
+	}
+}
+
+func TestHttpBoundedLoadWrites(t *testing.T) {
+	received := make(chan string)
+	release := make(chan struct{})
+	a := blockingNode(t, received, release)
+	b := blockingNode(t, received, release)
+
+	p := cache.NewHttpServer("http://self", cache.WithBoundedLoad(0.25))
+	p.Set(a.URL, b.URL)
+	owner, _ := p.PickOwner("hot")
+
+	// 负责的节点过载时读取溢出到另一个节点, 写入仍然发给负责的节点
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(release)
+	for i := 0; i < 6; i++ {
+		peer, _ := p.PickPeer("hot")
+		if peer != owner {
+			if w, _ := p.PickOwner("hot"); w != owner {
+				t.Fatalf("writes should stay on the owner")
+			}
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer.Get(context.Background(), &pb.Request{Group: "g", Key: "hot"}, &pb.Response{})
+		}()
+		<-received
+	}
+	t.Fatalf("reads should overflow to the other peer")
+}
+
+func TestHttpUnboundedLoad(t *testing.T) {
+	p := cache.NewHttpServer("http://self")
+	p.Set("http://a", "http://b")
+	first, _ := p.PickPeer("hot")
+	for i := 0; i < 10; i++ {
+		if peer, _ := p.PickPeer("hot"); peer != first {
+			t.Fatalf("without bounded load the owner should not change")
+		}
+	}
+}
+
+func TestHttpPicker(t *testing.T) {
+	picker := consistenthash.NewMaglev(0, nil)
+	p := cache.NewHttpServer("http://self", cache.WithPicker(picker), cache.WithBoundedLoad(0.25))
+	p.Set("http://self", "http://a", "http://b")
+
+	// 节点由设置的算法选择, 本节点负责的key在本地载入
+	for _, key := range []string{"Tom", "Jack", "Sam", "hot"} {
+		_, ok := p.PickPeer(key)
+		if remote := picker.Get(key) != "http://self"; ok != remote {
+			t.Fatalf("key %s: PickPeer should follow the picker", key)
+		}
+	}
+	// Maglev 不支持有界负载
+	if loads := p.Loads(); loads != nil {
+		t.Fatalf("bounded load should be disabled, got %v", loads)
+	}
+}
+
+func TestHttpPickerWithPeers(t *testing.T) {
+	// picker 中已经有节点时, 不需要再调用 Set
+	picker := consistenthash.NewRendezvous(nil)
+	picker.Add("http://self", "http://a")
+	p := cache.NewHttpServer("http://self", cache.WithPicker(picker))
+	for _, key := range []string{"Tom", "Jack", "Sam", "hot"} {
+		peer, ok := p.PickPeer(key)
+		if remote := picker.Get(key) != "http://self"; ok != remote || ok && peer == nil {
+			t.Fatalf("key %s: PickPeer should use the picker's peers", key)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// healthy 为0时返回500
+func flakyNode(t *testing.T, healthy *int32, hits *int32) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		if atomic.LoadInt32(healthy) == 0 {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("remote")})
+		w.Write(body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy, hits int32
+	ts := flakyNode(t, &healthy, &hits)
+	p := cache.NewHttpServer("http://self", cache.WithRetries(0, 0),
+		cache.WithCircuitBreaker(3, 0.5, 0, 50*time.Millisecond))
+	p.Set(ts.URL)
+
+	// 连续失败之后熔断
+	for i := 0; i < 3; i++ {
+		peer, ok := p.PickPeer("Tom")
+		if !ok {
+			t.Fatalf("the breaker should be closed before %d failures", i+1)
+		}
+		if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "Tom"}, &pb.Response{}); err == nil {
+			t.Fatalf("flaky peer should fail")
+		}
+	}
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("PickPeer should skip a peer with an open breaker")
+	}
+	if s := p.Breakers()[ts.URL]; s.State != "open" || s.Opens != 1 {
+		t.Fatalf("unexpected breaker status %+v", s)
+	}
+
+	// 等待之后放行一个探测请求, 成功后恢复; 选择节点不占用探测机会
+	time.Sleep(60 * time.Millisecond)
+	peer, ok := p.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("a probe should be allowed after the open timeout")
+	}
+	if _, ok := p.PickPeer("Tom"); !ok {
+		t.Fatalf("picking a peer should not use up the probe")
+	}
+	atomic.StoreInt32(&healthy, 1)
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.Breakers()[ts.URL]; s.State != "closed" {
+		t.Fatalf("successful probe should close the breaker, got %+v", s)
+	}
+	if _, ok := p.PickPeer("Tom"); !ok {
+		t.Fatalf("PickPeer should return the recovered peer")
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	var healthy, hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("remote")})
+		w.Write(body)
+	}))
+	defer ts.Close()
+	p := cache.NewHttpServer("http://self", cache.WithRetries(0, 0),
+		cache.WithCircuitBreaker(1, 0.5, 0, 20*time.Millisecond))
+	p.Set(ts.URL)
+	peer, _ := p.PickPeer("Tom")
+	peer.Get(context.Background(), &pb.Request{Group: "g", Key: "Tom"}, &pb.Response{})
+	time.Sleep(30 * time.Millisecond)
+	atomic.StoreInt32(&healthy, 1)
+
+	// 选择副本不占用探测机会; half-open 状态下同时只有一个请求发送给节点
+	for i := 0; i < 3; i++ {
+		if replicas := p.PickReplicas("Tom", 1); len(replicas) != 1 {
+			t.Fatalf("the peer should be picked in half-open state")
+		}
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- peer.Get(context.Background(), &pb.Request{Group: "g", Key: "Tom"}, &pb.Response{})
+		}()
+	}
+	var failed int
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	if n := atomic.LoadInt32(&hits); failed != 1 || n != 2 {
+		t.Fatalf("only one probe should be sent, failed %d, hits %d", failed, n)
+	}
+}
+
+func TestCircuitBreakerSlow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("slow")})
+		w.Write(body)
+	}))
+	defer ts.Close()
+	p := cache.NewHttpServer("http://self", cache.WithCircuitBreaker(1, 0.5, 10*time.Millisecond, time.Minute))
+	p.Set(ts.URL)
+
+	// 请求成功但是太慢, 同样会熔断
+	peer, _ := p.PickPeer("Tom")
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("slow peer should be ejected")
+	}
+}
+
+func TestCircuitBreakerFallback(t *testing.T) {
+	var healthy, hits int32
+	ts := flakyNode(t, &healthy, &hits)
+	p := cache.NewHttpServer("http://self", cache.WithRetries(0, 0),
+		cache.WithCircuitBreaker(2, 0.5, 0, time.Minute))
+	p.Set(ts.URL)
+	gee := cache.NewGroup("breaker-fallback", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}))
+	gee.RegisterPeers(p)
+
+	for _, key := range []string{"Tom", "Jack", "Sam"} {
+		if v, err := gee.Get(key); err != nil || v.String() != "db-"+key {
+			t.Fatalf("should fall back to local: %q, %v", v.String(), err)
+		}
+	}
+	// 熔断之后不再访问节点
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("expect 2 requests before the breaker opens, got %d", n)
+	}
+}
+
+func TestCircuitBreakerWrites(t *testing.T) {
+	var healthy, hits int32
+	ts := flakyNode(t, &healthy, &hits)
+	p := cache.NewHttpServer("http://self", cache.WithRetries(0, 0),
+		cache.WithCircuitBreaker(2, 0.5, 0, time.Minute))
+	p.Set(ts.URL)
+	gee := cache.NewGroup("breaker-writes", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}))
+	gee.RegisterPeers(p)
+	gee.Get("Tom")
+	gee.Get("Jack")
+	if s := p.Breakers()[ts.URL]; s.State != "open" {
+		t.Fatalf("the breaker should be open, got %+v", s)
+	}
+
+	// 熔断之后删除仍然发送给负责的节点, 失败时返回错误
+	if err := gee.Remove("Tom"); err == nil || atomic.LoadInt32(&hits) != 3 {
+		t.Fatalf("remove should reach the owner and fail, got %v", err)
+	}
+	atomic.StoreInt32(&healthy, 1)
+	if err := gee.Remove("Tom"); err != nil || atomic.LoadInt32(&hits) != 4 {
+		t.Fatalf("remove should reach the owner, got %v", err)
+	}
+	if err := gee.Set("Tom", []byte("630")); err != nil || atomic.LoadInt32(&hits) != 5 {
+		t.Fatalf("set should reach the owner, got %v", err)
+	}
+	// 写入的结果不影响熔断器
+	if s := p.Breakers()[ts.URL]; s.State != "open" {
+		t.Fatalf("writes should not close the breaker, got %+v", s)
+	}
+}
+
+func TestBreakerEndpoint(t *testing.T) {
+	var healthy, hits int32
+	flaky := flakyNode(t, &healthy, &hits)
+	p := cache.NewHttpServer("http://self", cache.WithRetries(0, 0),
+		cache.WithCircuitBreaker(1, 0.5, 0, time.Minute))
+	p.Set(flaky.URL)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	peer, _ := p.PickPeer("Tom")
+	peer.Get(context.Background(), &pb.Request{Group: "g", Key: "Tom"}, &pb.Response{})
+
+	res, err := http.Get(ts.URL + "/admin/breakers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	status := make(map[string]cache.BreakerStatus)
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if s := status[flaky.URL]; s.State != "open" || s.Failures != 0 || s.Opens != 1 {
+		t.Fatalf("unexpected breaker status %+v", status)
+	}
+
+	res, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	for _, line := range []string{
+		"# TYPE minicache_peer_breaker_state gauge",
+		`minicache_peer_breaker_state{peer="` + flaky.URL + `",state="closed"} 0`,
+		`minicache_peer_breaker_state{peer="` + flaky.URL + `",state="open"} 1`,
+		`minicache_peer_breaker_state{peer="` + flaky.URL + `",state="half-open"} 0`,
+		`minicache_peer_breaker_opens_total{peer="` + flaky.URL + `"} 1`,
+	} {
+		if !bytes.Contains(b, []byte(line+"\n")) {
+			t.Errorf("metrics should contain %q", line)
+		}
+	}
+	if strings.Contains(string(b), `peer="http://self"`) {
+		t.Errorf("self should not have a breaker")
+	}
+}
+
+func TestBreakerPerServer(t *testing.T) {
+	var healthy, hits int32
+	flaky := flakyNode(t, &healthy, &hits)
+	p1 := cache.NewHttpServer("http://self1", cache.WithRetries(0, 0),
+		cache.WithCircuitBreaker(1, 0.5, 0, time.Minute))
+	p2 := cache.NewHttpServer("http://self2", cache.WithRetries(0, 0),
+		cache.WithCircuitBreaker(1, 0.5, 0, time.Minute))
+	p1.Set(flaky.URL)
+	p2.Set(flaky.URL)
+
+	// 同一个进程中的两个 HttpServer 访问同一个节点, 熔断器互不影响
+	peer, _ := p1.PickPeer("Tom")
+	peer.Get(context.Background(), &pb.Request{Group: "g", Key: "Tom"}, &pb.Response{})
+	if s := p2.Breakers()[flaky.URL]; s.State != "closed" {
+		t.Fatalf("the other server's breaker should stay closed, got %+v", s)
+	}
+	ts := httptest.NewServer(p2)
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	if line := `minicache_peer_breaker_state{peer="` + flaky.URL + `",state="closed"} 1`; !bytes.Contains(b, []byte(line+"\n")) {
+		t.Errorf("metrics should contain %q", line)
+	}
+	// 关闭的节点不再输出
+	p1.SetPeers()
+	if len(p1.Breakers()) != 0 {
+		t.Fatalf("removed peers should have no breaker")
+	}
+}
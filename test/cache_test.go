@@ -1,10 +1,14 @@
 package cache_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"runtime"
 	"testing"
+	"time"
 
 	cache "mini-cache"
 )
@@ -57,3 +61,173 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestGetContextCancel(t *testing.T) {
+	started := make(chan struct{})
+	gee := cache.NewGroupContext("slow", 2<<10, cache.ContextGettrFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := gee.GetContext(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	<-started
+}
+
+func TestGetContextWaiterCancel(t *testing.T) {
+	release := make(chan struct{})
+	gee := cache.NewGroup("blocking", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			return []byte(key), nil
+		}))
+	defer close(release)
+
+	// 回调函数不感知 ctx, 调用方依然应该在超时后返回
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := gee.GetContext(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
+
+func TestGetContextSharedLoad(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	gee := cache.NewGroupContext("shared-load", 2<<10, cache.ContextGettrFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			close(started)
+			select {
+			case <-release:
+				return []byte(key), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}))
+
+	// 第一个调用方取消, 其他等待同一个key的调用方仍然得到结果
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := gee.GetContext(ctx, "key")
+		errc <- err
+	}()
+	<-started
+	done := make(chan string)
+	go func() {
+		v, err := gee.Get("key")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- v.String()
+	}()
+	// 等待第二个调用方加入
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+	close(release)
+	if v := <-done; v != "key" {
+		t.Fatalf("the other caller should get the value, got %q", v)
+	}
+}
+
+func TestGetContextAllCanceled(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	gee := cache.NewGroupContext("all-canceled", 2<<10, cache.ContextGettrFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}))
+
+	// 所有调用方都取消之后, 载入的 ctx 也被取消, 不会一直执行到 loadTimeout
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errc := make(chan error, 2)
+	go func() {
+		_, err := gee.GetContext(ctx1, "key")
+		errc <- err
+	}()
+	<-started
+	go func() {
+		_, err := gee.GetContext(ctx2, "key")
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel1()
+	cancel2()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expect canceled, got %v", err)
+		}
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("the load should be canceled after every caller has left")
+	}
+}
+
+func TestGetTTL(t *testing.T) {
+	loads := 0
+	gee := cache.NewGroupContext("ttl", 2<<10, cache.TTLGettrFunc(
+		func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			loads++
+			if key == "short" {
+				return []byte(key), 20 * time.Millisecond, nil
+			}
+			// 使用默认过期时间
+			return []byte(key), 0, nil
+		}), cache.WithDefaultTTL(time.Hour))
+
+	for _, k := range []string{"short", "long"} {
+		if _, err := gee.Get(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	for _, k := range []string{"short", "long"} {
+		if _, err := gee.Get(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// short 过期后重新载入, long 仍然命中
+	if loads != 3 {
+		t.Fatalf("expect 3 loads, got %d", loads)
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	gee := cache.NewGroup("close", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), cache.WithDefaultTTL(time.Hour))
+	// 写入会过期的数据, 启动后台清理
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+
+	gee.Close()
+	if _, ok := cache.GetGroup("close"); ok {
+		t.Fatalf("closed group should be unregistered")
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("sweepers should stop after Close, goroutines %d > %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("closed group should still serve reads: %q, %v", v.String(), err)
+	}
+}
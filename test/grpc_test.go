@@ -0,0 +1,95 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// 在内存中启动一个gRPC节点, 返回连接它使用的参数
+func startBufconnServer(t *testing.T) grpc.DialOption {
+	lis := bufconn.Listen(1 << 20)
+	server := cache.NewGrpcServer("owner")
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	})
+}
+
+func TestGrpcPeer(t *testing.T) {
+	var deadline bool
+	gee := cache.NewGroupContext("grpc-get", 2<<10, cache.ContextGettrFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			_, deadline = ctx.Deadline()
+			if key == "unknown" {
+				return nil, errors.New("not exist")
+			}
+			return []byte("v-" + key), nil
+		}))
+
+	client := cache.NewGrpcServer("self", startBufconnServer(t))
+	client.Set("owner")
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("PickPeer should return the remote peer")
+	}
+
+	// 请求的超时时间会传递给远程节点, 远程节点的回调函数使用自己的超时时间
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res := &pb.Response{}
+	if err := peer.Get(ctx, &pb.Request{Group: "grpc-get", Key: "Tom"}, res); err != nil || string(res.GetValue()) != "v-Tom" {
+		t.Fatalf("get over grpc failed: %v", err)
+	}
+	if !deadline {
+		t.Fatalf("the owner should load with a deadline")
+	}
+	if err := peer.Get(ctx, &pb.Request{Group: "grpc-get", Key: "unknown"}, &pb.Response{}); err == nil {
+		t.Fatalf("the value of unknown should be empty")
+	}
+	if err := peer.Get(ctx, &pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatalf("no such group should fail")
+	}
+
+	batch := &pb.BatchResponse{}
+	if err := peer.GetMany(ctx, &pb.BatchRequest{Group: "grpc-get", Keys: []string{"Tom", "unknown"}}, batch); err != nil {
+		t.Fatal(err)
+	}
+	if e := batch.GetEntries(); len(e) != 2 || string(e[0].GetValue()) != "v-Tom" || e[1].GetError() == "" {
+		t.Fatalf("unexpected batch response %v", e)
+	}
+
+	if err := peer.Set(ctx, &pb.SetRequest{Group: "grpc-get", Key: "Jack", Value: []byte("589")}, &pb.Ack{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "589" {
+		t.Fatalf("set over grpc failed")
+	}
+	if err := peer.Remove(ctx, &pb.Request{Group: "grpc-get", Key: "Jack"}, &pb.Ack{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "v-Jack" {
+		t.Fatalf("remove over grpc failed")
+	}
+}
+
+func TestGrpcPickSelf(t *testing.T) {
+	server := cache.NewGrpcServer("self")
+	defer server.Stop()
+	if _, ok := server.PickPeer("Tom"); ok {
+		t.Fatalf("PickPeer should fail without peers")
+	}
+	server.Set("self")
+	if _, ok := server.PickPeer("Tom"); ok {
+		t.Fatalf("PickPeer should not return itself")
+	}
+}
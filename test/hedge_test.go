@@ -0,0 +1,138 @@
+package cache_test
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+)
+
+func TestHedgeToReplica(t *testing.T) {
+	slow := &fakePeer{delay: time.Second, prefix: "slow-"}
+	fast := &fakePeer{prefix: "fast-"}
+	gee := cache.NewGroup("hedge-replica", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithReplicas(2), cache.WithHedging(0.95, 10*time.Millisecond, 1))
+	gee.RegisterPeers(replicaPicker{slow, fast})
+
+	// 负责的节点太慢, 对冲请求发给下一个副本, 慢的请求被取消
+	start := time.Now()
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "fast-Tom" {
+		t.Fatalf("hedged request should win: %q, %v", v.String(), err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("hedging should cut the latency")
+	}
+	if gee.Stats.Hedges.Get() != 1 || gee.Stats.HedgeWins.Get() != 1 || gee.Stats.LocalLoads.Get() != 0 {
+		t.Fatalf("unexpected stats: hedges %v, wins %v", &gee.Stats.Hedges, &gee.Stats.HedgeWins)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&slow.canceled) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the slow request should be canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHedgeToLocal(t *testing.T) {
+	gee := cache.NewGroup("hedge-local", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithHedging(0.95, 10*time.Millisecond, 1))
+	gee.RegisterPeers(fakePicker{peer: &fakePeer{delay: time.Second, prefix: "slow-"}})
+
+	// 没有副本时对冲到本地载入
+	start := time.Now()
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("should hedge to the local loader: %q, %v", v.String(), err)
+	}
+	if time.Since(start) > 500*time.Millisecond || gee.Stats.HedgeWins.Get() != 1 {
+		t.Fatalf("hedging should cut the latency")
+	}
+}
+
+func TestHedgeLocalError(t *testing.T) {
+	gee := cache.NewGroup("hedge-local-error", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db down")
+		}), cache.WithHedging(0.95, 10*time.Millisecond, 1))
+	peer := &fakePeer{delay: 100 * time.Millisecond, prefix: "slow-"}
+	gee.RegisterPeers(fakePicker{peer: peer})
+
+	// 对冲的本地载入失败, 不影响较慢的远程请求
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "slow-Tom" {
+		t.Fatalf("the slow peer should still win: %q, %v", v.String(), err)
+	}
+	if gee.Stats.Hedges.Get() != 1 || gee.Stats.HedgeWins.Get() != 0 || atomic.LoadInt32(&peer.canceled) != 0 {
+		t.Fatalf("unexpected stats: hedges %v, wins %v", &gee.Stats.Hedges, &gee.Stats.HedgeWins)
+	}
+
+	// 都失败时返回错误
+	peer.setErr(errors.New("connection refused"))
+	if _, err := gee.Get("Jack"); err == nil {
+		t.Fatalf("should fail when both the peer and the hedge fail")
+	}
+}
+
+func TestHedgeOwnerError(t *testing.T) {
+	gee := cache.NewGroup("hedge-owner-error", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			time.Sleep(100 * time.Millisecond)
+			return []byte("db-" + key), nil
+		}), cache.WithHedging(0.95, 10*time.Millisecond, 1))
+	// 负责的节点载入失败, 返回502
+	peer, _ := startPeer(t, func(int32) (int, []byte) {
+		time.Sleep(50 * time.Millisecond)
+		return http.StatusBadGateway, nil
+	})
+	gee.RegisterPeers(fakePicker{peer: peer})
+
+	// 对冲的本地载入已经开始, 等待它的结果, 而不是直接返回负责的节点的错误
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("should wait for the local hedge: %q, %v", v.String(), err)
+	}
+	if gee.Stats.PeerErrors.Get() != 1 || gee.Stats.HedgeWins.Get() != 1 {
+		t.Fatalf("unexpected stats: peer errors %v, wins %v", &gee.Stats.PeerErrors, &gee.Stats.HedgeWins)
+	}
+}
+
+func TestHedgeFastPeer(t *testing.T) {
+	gee := cache.NewGroup("hedge-fast", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithHedging(0.95, 100*time.Millisecond, 1))
+	gee.RegisterPeers(fakePicker{peer: &fakePeer{prefix: "remote-"}})
+
+	// 负责的节点及时返回, 不发出对冲请求
+	for _, key := range []string{"Tom", "Jack", "Sam"} {
+		if v, err := gee.Get(key); err != nil || v.String() != "remote-"+key {
+			t.Fatalf("unexpected value %q, %v", v.String(), err)
+		}
+	}
+	if gee.Stats.Hedges.Get() != 0 || gee.Stats.PeerLoads.Get() != 3 {
+		t.Fatalf("fast peers should not be hedged, hedges %v", &gee.Stats.Hedges)
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	gee := cache.NewGroup("hedge-budget", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithHedging(0.95, time.Millisecond, 0.5), cache.WithPeerTimeout(50*time.Millisecond))
+	gee.RegisterPeers(fakePicker{peer: &fakePeer{delay: time.Second, prefix: "slow-"}})
+
+	// 每两个请求才有一次对冲的额度
+	for _, key := range []string{"Tom", "Jack", "Sam", "Ann"} {
+		if v, err := gee.Get(key); err != nil || v.String() != "db-"+key {
+			t.Fatalf("unexpected value %q, %v", v.String(), err)
+		}
+	}
+	if gee.Stats.Hedges.Get() != 2 {
+		t.Fatalf("expect 2 hedges within the budget, got %v", &gee.Stats.Hedges)
+	}
+}
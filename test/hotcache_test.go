@@ -0,0 +1,72 @@
+package cache_test
+
+import (
+	"errors"
+	"testing"
+
+	cache "mini-cache"
+)
+
+// 记录访问次数的远程节点
+type countingPicker struct {
+	peer  *fakePeer
+	count int
+}
+
+func (c *countingPicker) PickPeer(key string) (cache.PeerServer, bool) {
+	c.count++
+	return c.peer, true
+}
+
+func TestHotCache(t *testing.T) {
+	picker := &countingPicker{peer: &fakePeer{values: map[string][]byte{"Tom": []byte("630")}}}
+	gee := cache.NewGroup("hot", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db down")
+		}), cache.WithHotCache(1<<10, 1))
+	gee.RegisterPeers(picker)
+
+	for i := 0; i < 3; i++ {
+		if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+			t.Fatalf("get Tom from peer failed: %v", err)
+		}
+	}
+	// 第一次之后命中热点缓存, 不再选择节点
+	if picker.count != 1 {
+		t.Fatalf("hot key should be served locally, picked peer %d times", picker.count)
+	}
+	if hits := gee.Stats.HotCacheHits.Get(); hits != 2 {
+		t.Fatalf("expect 2 hot cache hits, got %d", hits)
+	}
+	if s := gee.CacheStats(cache.HotCache); s.Items != 1 || s.Bytes != 3 {
+		t.Fatalf("unexpected hot cache stats %+v", s)
+	}
+	if s := gee.CacheStats(cache.MainCache); s.Items != 0 {
+		t.Fatalf("peer values should not be stored in the main cache, got %+v", s)
+	}
+
+	// 删除时同时清除热点缓存中的副本
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if s := gee.CacheStats(cache.HotCache); s.Items != 0 {
+		t.Fatalf("hot copy should be removed, got %+v", s)
+	}
+}
+
+func TestHotCacheDisabled(t *testing.T) {
+	picker := &countingPicker{peer: &fakePeer{values: map[string][]byte{"Tom": []byte("630")}}}
+	gee := cache.NewGroup("hot-disabled", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db down")
+		}))
+	gee.RegisterPeers(picker)
+
+	// 默认不使用热点缓存, 远程数据不会留在本地
+
+	gee.Get("Tom")
+	gee.Get("Tom")
+	if picker.count != 2 {
+		t.Fatalf("every get should go to the peer, picked %d times", picker.count)
+	}
+}
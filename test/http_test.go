@@ -0,0 +1,166 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+)
+
+func TestSetRemoveLocal(t *testing.T) {
+	gee := cache.NewGroup("set-local", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db down")
+		}))
+
+	if err := gee.Set("Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("Set Tom=630 failed")
+	}
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gee.Get("Tom"); err == nil {
+		t.Fatalf("Tom should be removed")
+	}
+}
+
+func TestSetRemovePeer(t *testing.T) {
+	peer := &fakePeer{values: make(map[string][]byte)}
+	gee := cache.NewGroup("set-peer", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db down")
+		}))
+	gee.RegisterPeers(fakePicker{peer: peer})
+
+	if err := gee.Set("Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := peer.value("Tom"); v != "630" {
+		t.Fatalf("Set should be sent to the owner peer")
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("get Tom from peer failed")
+	}
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if len(peer.removed) != 1 || peer.removed[0] != "Tom" {
+		t.Fatalf("Remove should be sent to the owner peer")
+	}
+	if _, err := gee.Get("Tom"); err == nil {
+		t.Fatalf("Tom should be removed")
+	}
+}
+
+func TestHttpSetRemove(t *testing.T) {
+	gee := cache.NewGroup("http-set", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db down")
+		}))
+	ts := httptest.NewServer(cache.NewHttpServer("http://owner"))
+	defer ts.Close()
+
+	client := cache.NewHttpServer("http://self")
+	client.Set(ts.URL)
+	peer, ok := client.PickPeer("a key")
+	if !ok {
+		t.Fatalf("PickPeer should return the remote peer")
+	}
+
+	ctx := context.Background()
+	if err := peer.Set(ctx, &pb.SetRequest{Group: "http-set", Key: "a key", Value: []byte("v")}, &pb.Ack{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := gee.Get("a key"); err != nil || v.String() != "v" {
+		t.Fatalf("set over http failed")
+	}
+	res := &pb.Response{}
+	if err := peer.Get(ctx, &pb.Request{Group: "http-set", Key: "a key"}, res); err != nil || string(res.GetValue()) != "v" {
+		t.Fatalf("get over http failed: %v", err)
+	}
+	if err := peer.Remove(ctx, &pb.Request{Group: "http-set", Key: "a key"}, &pb.Ack{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gee.Get("a key"); err == nil {
+		t.Fatalf("remove over http failed")
+	}
+}
+
+func TestHttpSetPeers(t *testing.T) {
+	cache.NewGroup("http-set-peers", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	a := httptest.NewServer(cache.NewHttpServer("http://a"))
+	defer a.Close()
+	b := httptest.NewServer(cache.NewHttpServer("http://b"))
+	defer b.Close()
+
+	p := cache.NewHttpServer("http://self")
+	p.Set(a.URL, b.URL)
+	oldPeer, ok := p.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("PickPeer should return a remote peer")
+	}
+
+	// 替换节点之后只剩下b, 所有的key都属于b
+	p.SetPeers("http://self", b.URL)
+	if peers := p.Peers(); len(peers) != 2 {
+		t.Fatalf("expect 2 peers, got %v", peers)
+	}
+	for _, key := range []string{"Tom", "Jack", "Sam"} {
+		peer, ok := p.PickPeer(key)
+		if !ok {
+			continue // 属于本节点
+		}
+		res := &pb.Response{}
+		if err := peer.Get(context.Background(), &pb.Request{Group: "http-set-peers", Key: key}, res); err != nil {
+			t.Fatalf("get %s from b failed: %v", key, err)
+		}
+	}
+
+	// 离开集群的节点的客户端已经关闭
+	p.SetPeers("http://self")
+	if err := oldPeer.Get(context.Background(), &pb.Request{Group: "http-set-peers", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatalf("client of a removed peer should be closed")
+	}
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("PickPeer should not return removed peers")
+	}
+}
+
+func TestHttpRejectBadWrites(t *testing.T) {
+	gee := cache.NewGroup("http-bad-writes", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}))
+	ts := httptest.NewServer(cache.NewHttpServer("http://owner", cache.WithMaxRequestBytes(4)))
+	defer ts.Close()
+	base := ts.URL + "/api/cache/http-bad-writes"
+
+	// 没有key的写入返回400, 请求体过大返回413
+	for _, c := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPut, "/", http.StatusBadRequest},
+		{http.MethodDelete, "/", http.StatusBadRequest},
+		{http.MethodGet, "/", http.StatusBadRequest},
+		{http.MethodPut, "/Tom", http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "", http.StatusRequestEntityTooLarge},
+	} {
+		if code, _ := statusOf(c.method, base+c.path); code != c.code {
+			t.Fatalf("%s %s: expect %d, got %d", c.method, c.path, c.code, code)
+		}
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("the rejected write should not be cached, got %q, %v", v.String(), err)
+	}
+}
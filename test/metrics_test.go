@@ -0,0 +1,119 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+)
+
+func TestStats(t *testing.T) {
+	gee := cache.NewGroup("stats", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			if key == "missing" {
+				return nil, errors.New("not exist")
+			}
+			return []byte(key), nil
+		}))
+
+	gee.Get("Tom")
+	gee.Get("Tom")
+	gee.Get("missing")
+
+	s := &gee.Stats
+	for name, c := range map[string]struct{ got, expect int64 }{
+		"gets":          {s.Gets.Get(), 3},
+		"hits":          {s.CacheHits.Get(), 1},
+		"misses":        {s.CacheMisses.Get(), 2},
+		"loads":         {s.Loads.Get(), 2},
+		"loads deduped": {s.LoadsDeduped.Get(), 2},
+		"local loads":   {s.LocalLoads.Get(), 1},
+		"local errors":  {s.LocalLoadErrs.Get(), 1},
+	} {
+		if c.got != c.expect {
+			t.Errorf("%s: expect %d, got %d", name, c.expect, c.got)
+		}
+	}
+	if cs := gee.CacheStats(cache.MainCache); cs.Items != 1 || cs.Bytes != 3 {
+		t.Errorf("unexpected cache stats %+v", cs)
+	}
+}
+
+func TestPeerStats(t *testing.T) {
+	peer := &fakePeer{values: map[string][]byte{"Tom": []byte("630")}}
+	gee := cache.NewGroup("peer-stats", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db"), nil
+		}))
+	gee.RegisterPeers(fakePicker{peer: peer})
+
+	gee.Get("Tom")
+	gee.Get("Jack") // 远程节点没有, 回退到本地
+	if gee.Stats.PeerLoads.Get() != 1 || gee.Stats.PeerErrors.Get() != 1 || gee.Stats.LocalLoads.Get() != 1 {
+		t.Fatalf("unexpected peer stats: loads %v, errors %v, local %v",
+			&gee.Stats.PeerLoads, &gee.Stats.PeerErrors, &gee.Stats.LocalLoads)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	cache.NewGroup("metrics", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	ts := httptest.NewServer(cache.NewHttpServer("http://owner"))
+	defer ts.Close()
+
+	// 通过HTTP访问, 记录节点延迟
+	client := cache.NewHttpServer("http://self")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("Tom")
+	if err := peer.Get(context.Background(), &pb.Request{Group: "metrics", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type %s", res.Header.Get("Content-Type"))
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	body := string(b)
+	for _, line := range []string{
+		"# TYPE minicache_gets_total counter",
+		`minicache_gets_total{group="metrics"} 1`,
+		`minicache_server_requests_total{group="metrics"} 1`,
+		`minicache_cache_items{group="metrics",cache="main"} 1`,
+		`minicache_cache_bytes{group="metrics",cache="main"} 3`,
+		`minicache_cache_bytes{group="metrics",cache="hot"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics should contain %q", line)
+		}
+	}
+
+	// 节点延迟只在发出请求的 HttpServer 上输出, 节点离开之后删除
+	latency := `minicache_peer_request_duration_seconds_count{peer="` + ts.URL + `"} 1`
+	if body := serverMetrics(client); !strings.Contains(body, latency+"\n") {
+		t.Errorf("metrics should contain %q", latency)
+	}
+	client.SetPeers()
+	if body := serverMetrics(client); strings.Contains(body, ts.URL) {
+		t.Errorf("the removed peer should be dropped from metrics")
+	}
+}
+
+// HttpServer 的 /metrics 的输出
+func serverMetrics(p *cache.HttpServer) string {
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rec.Body.String()
+}
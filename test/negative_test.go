@@ -0,0 +1,141 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+)
+
+// 返回数据源被访问的次数
+func notFoundGettr(calls *int32) cache.GettrFunc {
+	return func(key string) ([]byte, error) {
+		atomic.AddInt32(calls, 1)
+		switch key {
+		case "Tom":
+			return []byte("630"), nil
+		case "broken":
+			return nil, errors.New("db down")
+		}
+		return nil, fmt.Errorf("%s not exist: %w", key, cache.ErrNotFound)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	var calls int32
+	gee := cache.NewGroup("negative", 2<<10, notFoundGettr(&calls), cache.WithNegativeCache(50*time.Millisecond, 100))
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if calls != 1 || gee.Stats.NegativeHits.Get() != 2 {
+		t.Fatalf("not found should be cached, db calls %d, negative hits %v", calls, &gee.Stats.NegativeHits)
+	}
+
+	// 其他错误不会被缓存
+	gee.Get("broken")
+	gee.Get("broken")
+	if calls != 3 {
+		t.Fatalf("other errors should not be cached, db calls %d", calls)
+	}
+
+	// 过期之后重新访问数据源
+	time.Sleep(60 * time.Millisecond)
+	gee.Get("unknown")
+	if calls != 4 {
+		t.Fatalf("negative entry should expire, db calls %d", calls)
+	}
+
+	// 写入之后不再返回 ErrNotFound
+	if err := gee.Set("unknown", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := gee.Get("unknown"); err != nil || v.String() != "v" {
+		t.Fatalf("set should clear the negative entry, got %v", err)
+	}
+}
+
+func TestNegativeCacheLimit(t *testing.T) {
+	var calls int32
+	gee := cache.NewGroup("negative-limit", 2<<10, notFoundGettr(&calls), cache.WithNegativeCache(time.Minute, 2))
+	for _, key := range []string{"a", "b", "c"} {
+		gee.Get(key)
+	}
+	if s := gee.CacheStats(cache.NegativeCache); s.Items != 2 {
+		t.Fatalf("negative cache should hold at most 2 keys, got %+v", s)
+	}
+
+	disabled := cache.NewGroup("negative-disabled", 2<<10, notFoundGettr(&calls), cache.WithNegativeCache(0, 0))
+	calls = 0
+	disabled.Get("a")
+	disabled.Get("a")
+	if calls != 2 {
+		t.Fatalf("negative cache should be disabled, db calls %d", calls)
+	}
+}
+
+func TestNegativePeer(t *testing.T) {
+	var calls int32
+	peer := &fakePeer{values: map[string][]byte{}, notFound: true}
+	gee := cache.NewGroup("negative-peer", 2<<10, notFoundGettr(&calls))
+	gee.RegisterPeers(fakePicker{peer: peer})
+
+	// 负责的节点确认不存在时不回退到本地
+	if _, err := gee.Get("Tom"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	_, errs := gee.GetMany(context.Background(), []string{"Tom", "Jack"})
+	if !errors.Is(errs[0], cache.ErrNotFound) || !errors.Is(errs[1], cache.ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", errs)
+	}
+	if calls != 0 || gee.Stats.PeerErrors.Get() != 0 {
+		t.Fatalf("not found from the owner should not fall back, db calls %d, peer errors %v", calls, &gee.Stats.PeerErrors)
+	}
+}
+
+func TestNotFoundOverTheWire(t *testing.T) {
+	var calls int32
+	cache.NewGroup("negative-wire", 2<<10, notFoundGettr(&calls))
+	ts := httptest.NewServer(cache.NewHttpServer("http://owner"))
+	defer ts.Close()
+	httpPeers := cache.NewHttpServer("http://self")
+	httpPeers.Set(ts.URL)
+	httpPeer, _ := httpPeers.PickPeer("unknown")
+
+	grpcPeers := cache.NewGrpcServer("self", startBufconnServer(t))
+	grpcPeers.Set("owner")
+	grpcPeer, _ := grpcPeers.PickPeer("unknown")
+
+	ctx := context.Background()
+	for name, peer := range map[string]cache.PeerServer{"http": httpPeer, "grpc": grpcPeer} {
+		err := peer.Get(ctx, &pb.Request{Group: "negative-wire", Key: "unknown"}, &pb.Response{})
+		if !errors.Is(err, cache.ErrNotFound) {
+			t.Errorf("%s: expect ErrNotFound, got %v", name, err)
+		}
+		// 其他错误和不存在的group不是 ErrNotFound
+		err = peer.Get(ctx, &pb.Request{Group: "negative-wire", Key: "broken"}, &pb.Response{})
+		if err == nil || errors.Is(err, cache.ErrNotFound) {
+			t.Errorf("%s: expect a plain error, got %v", name, err)
+		}
+		err = peer.Get(ctx, &pb.Request{Group: "no-such-group", Key: "unknown"}, &pb.Response{})
+		if err == nil || errors.Is(err, cache.ErrNotFound) {
+			t.Errorf("%s: no such group should not be ErrNotFound, got %v", name, err)
+		}
+
+		res := &pb.BatchResponse{}
+		if err := peer.GetMany(ctx, &pb.BatchRequest{Group: "negative-wire", Keys: []string{"unknown", "Tom"}}, res); err != nil {
+			t.Fatal(err)
+		}
+		if e := res.GetEntries(); !e[0].GetNotFound() || e[1].GetNotFound() || string(e[1].GetValue()) != "630" {
+			t.Errorf("%s: unexpected batch response %v", name, e)
+		}
+	}
+}
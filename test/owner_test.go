@@ -0,0 +1,113 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+)
+
+// 启动一个HTTP节点, 返回连接它的客户端
+func startOwner(t *testing.T) cache.PeerServer {
+	ts := httptest.NewServer(cache.NewHttpServer("http://owner"))
+	t.Cleanup(ts.Close)
+	client := cache.NewHttpServer("http://self")
+	client.Set(ts.URL)
+	peer, ok := client.PickPeer("key")
+	if !ok {
+		t.Fatalf("PickPeer should return the owner")
+	}
+	return peer
+}
+
+func TestOwnerLoadOnce(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	gee := cache.NewGroup("owner-load", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return []byte("v-" + key), nil
+		}))
+	peer := startOwner(t)
+
+	// 请求方超时之后, 负责的节点仍然完成载入
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := peer.Get(ctx, &pb.Request{Group: "owner-load", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatalf("the request should time out")
+	}
+
+	// 多个节点同时请求, 负责的节点只载入一次
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &pb.Response{}
+			if err := peer.Get(context.Background(), &pb.Request{Group: "owner-load", Key: "Tom"}, res); err != nil || string(res.GetValue()) != "v-Tom" {
+				t.Errorf("get from owner failed: %q, %v", res.GetValue(), err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("the owner should load once, got %d", n)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "v-Tom" || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("the owner should cache the value")
+	}
+}
+
+func TestOwnerLoadError(t *testing.T) {
+	var local int32
+	gee := cache.NewGroup("owner-error-self", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&local, 1)
+			return []byte("db-" + key), nil
+		}))
+	// 负责的节点从数据源载入失败时返回502
+	peer, _ := startPeer(t, func(int32) (int, []byte) { return http.StatusBadGateway, nil })
+	gee.RegisterPeers(fakePicker{peer: peer})
+
+	// 负责的节点载入失败, 不会回退到本地再访问一次数据源
+	if _, err := gee.Get("Tom"); err == nil {
+		t.Fatalf("the owner's error should be returned")
+	}
+	if n := atomic.LoadInt32(&local); n != 0 || gee.Stats.PeerErrors.Get() != 1 {
+		t.Fatalf("should not fall back: local loads %d, peer errors %v", n, &gee.Stats.PeerErrors)
+	}
+}
+
+func TestPeerTimeoutFallback(t *testing.T) {
+	gee := cache.NewGroup("owner-timeout", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithPeerTimeout(20*time.Millisecond))
+	gee.RegisterPeers(fakePicker{peer: &fakePeer{delay: time.Hour}})
+
+	// 负责的节点没有响应, 超时之后回退到本地
+	start := time.Now()
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("should fall back to local: %q, %v", v.String(), err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("fallback should be bounded by the peer timeout")
+	}
+
+	// 调用方自己取消时不回退
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := gee.GetContext(ctx, "Jack"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+)
+
+// 代替远程节点, 记录收到的请求, 并发安全
+type fakePeer struct {
+	mu      sync.Mutex
+	values  map[string][]byte
+	removed []string
+	// 不存在的key返回 prefix+key, 为空时返回错误
+	prefix string
+	// 为true时不存在的key返回 cache.ErrNotFound
+	notFound bool
+	// 不为空时所有请求都返回这个错误
+	err error
+	// Get 返回之前等待的时间, ctx 先结束时返回 ctx.Err()
+	delay time.Duration
+
+	// 收到的 Get 和 GetMany 请求, 以及等待时被取消的 Get 请求
+	gets, batches, canceled int32
+}
+
+func (f *fakePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	atomic.AddInt32(&f.gets, 1)
+	if f.delay > 0 {
+		timer := time.NewTimer(f.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			atomic.AddInt32(&f.canceled, 1)
+			return ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	v, err := f.lookup(in.GetKey())
+	out.Value = v
+	return err
+}
+
+func (f *fakePeer) Set(ctx context.Context, in *pb.SetRequest, out *pb.Ack) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if f.values == nil {
+		f.values = make(map[string][]byte)
+	}
+	f.values[in.GetKey()] = in.GetValue()
+	return nil
+}
+
+func (f *fakePeer) Remove(ctx context.Context, in *pb.Request, out *pb.Ack) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	delete(f.values, in.GetKey())
+	f.removed = append(f.removed, in.GetKey())
+	return nil
+}
+
+func (f *fakePeer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	atomic.AddInt32(&f.batches, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	for _, key := range in.GetKeys() {
+		e := &pb.Entry{Key: key}
+		v, err := f.lookup(key)
+		switch {
+		case err == nil:
+			e.Value = v
+		case errors.Is(err, cache.ErrNotFound):
+			e.NotFound = true
+		default:
+			e.Error = err.Error()
+		}
+		out.Entries = append(out.Entries, e)
+	}
+	return nil
+}
+
+func (f *fakePeer) lookup(key string) ([]byte, error) {
+	if v, ok := f.values[key]; ok {
+		return v, nil
+	}
+	if f.prefix != "" {
+		return []byte(f.prefix + key), nil
+	}
+	if f.notFound {
+		return nil, cache.ErrNotFound
+	}
+	return nil, errors.New("not found")
+}
+
+// 之后的请求都返回err, 为nil时恢复
+func (f *fakePeer) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// 节点上保存的值
+func (f *fakePeer) value(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.values[key]
+	return string(v), ok
+}
+
+// 所有的key都属于同一个远程节点
+type fakePicker struct {
+	peer cache.PeerServer
+}
+
+func (f fakePicker) PickPeer(key string) (cache.PeerServer, bool) {
+	return f.peer, true
+}
+
+// 所有的key使用同样的副本, nil 表示本节点
+type replicaPicker []cache.PeerServer
+
+func (r replicaPicker) PickPeer(key string) (cache.PeerServer, bool) {
+	return r[0], r[0] != nil
+}
+
+func (r replicaPicker) PickReplicas(key string, n int) []cache.PeerServer {
+	if n > len(r) {
+		n = len(r)
+	}
+	return r[:n]
+}
@@ -0,0 +1,155 @@
+package cache_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+)
+
+// 每次载入返回新的版本, 载入耗时 delay
+func versionGettr(calls *int32, delay time.Duration) cache.GettrFunc {
+	return func(key string) ([]byte, error) {
+		n := atomic.AddInt32(calls, 1)
+		time.Sleep(delay)
+		return []byte(fmt.Sprintf("v%d", n)), nil
+	}
+}
+
+func waitValue(t *testing.T, g *cache.Group, key, expect string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, err := g.Get(key); err == nil && v.String() == expect {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%s should become %s", key, expect)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	gee := cache.NewGroup("stale", 2<<10, versionGettr(&calls, 20*time.Millisecond),
+		cache.WithDefaultTTL(200*time.Millisecond), cache.WithStaleWhileRevalidate(time.Second))
+
+	if v, _ := gee.Get("Tom"); v.String() != "v1" {
+		t.Fatalf("expect v1, got %s", v.String())
+	}
+	time.Sleep(220 * time.Millisecond)
+
+	// 软过期之后立即返回旧值, 只有一次后台刷新
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			v, err := gee.Get("Tom")
+			if err != nil || v.String() != "v1" {
+				t.Errorf("expect stale v1, got %s %v", v.String(), err)
+			}
+			if time.Since(start) > 15*time.Millisecond {
+				t.Errorf("stale get should not wait for the loader")
+			}
+		}()
+	}
+	wg.Wait()
+	// v2 的有效期远大于等待的时间, 等待期间不会再次软过期
+	waitValue(t, gee, "Tom", "v2")
+	if n := atomic.LoadInt32(&calls); n != 2 || gee.Stats.Refreshes.Get() != 1 || gee.Stats.StaleHits.Get() < 10 {
+		t.Fatalf("expect one refresh, db calls %d, refreshes %v, stale hits %v",
+			n, &gee.Stats.Refreshes, &gee.Stats.StaleHits)
+	}
+}
+
+func TestStaleHardExpire(t *testing.T) {
+	var calls int32
+	gee := cache.NewGroup("stale-hard", 2<<10, versionGettr(&calls, 0),
+		cache.WithDefaultTTL(10*time.Millisecond), cache.WithStaleWhileRevalidate(10*time.Millisecond))
+
+	gee.Get("Tom")
+	time.Sleep(30 * time.Millisecond)
+	// 硬过期之后是真正的未命中
+	if v, _ := gee.Get("Tom"); v.String() != "v2" {
+		t.Fatalf("expect v2 after the hard ttl, got %s", v.String())
+	}
+	if gee.Stats.StaleHits.Get() != 0 {
+		t.Fatalf("expired value should not be served")
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var calls int32
+	gee := cache.NewGroup("refresh-ahead", 2<<10, versionGettr(&calls, 0),
+		cache.WithDefaultTTL(60*time.Millisecond), cache.WithRefreshAhead(0.5))
+
+	// 一直被访问的key在过期之前被刷新, 不会出现未命中
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if _, err := gee.Get("Tom"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if misses := gee.Stats.CacheMisses.Get(); misses != 1 {
+		t.Fatalf("hot key should only miss once, got %d misses", misses)
+	}
+	if n := atomic.LoadInt32(&calls); n < 3 {
+		t.Fatalf("hot key should be refreshed in the background, db calls %d", n)
+	}
+}
+
+func TestRefreshNotFound(t *testing.T) {
+	var deleted int32
+	gee := cache.NewGroup("refresh-not-found", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			if atomic.LoadInt32(&deleted) == 1 {
+				return nil, cache.ErrNotFound
+			}
+			return []byte("630"), nil
+		}), cache.WithDefaultTTL(10*time.Millisecond), cache.WithStaleWhileRevalidate(time.Second))
+
+	gee.Get("Tom")
+	atomic.StoreInt32(&deleted, 1)
+	time.Sleep(20 * time.Millisecond)
+	// 返回旧值, 后台刷新发现已经删除
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("expect stale value, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := gee.Get("Tom"); errors.Is(err, cache.ErrNotFound) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("stale value should be dropped once the key is deleted")
+}
+
+func TestRefreshFromPeer(t *testing.T) {
+	peer := &fakePeer{prefix: "peer-"}
+	gee := cache.NewGroup("refresh-peer", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithDefaultTTL(50*time.Millisecond), cache.WithStaleWhileRevalidate(5*time.Second))
+	gee.RegisterPeers(fakePicker{peer: peer})
+
+	// 负责的节点没有响应时在本地载入, 之后刷新的值来自负责的节点
+	peer.setErr(errors.New("peer down"))
+	if v, _ := gee.Get("Tom"); v.String() != "db-Tom" {
+		t.Fatalf("expect the local value, got %s", v.String())
+	}
+	peer.setErr(nil)
+	time.Sleep(60 * time.Millisecond)
+
+	// 刷新的值写入本地缓存, 不再返回旧值, 也不需要再访问负责的节点
+	waitValue(t, gee, "Tom", "peer-Tom")
+	gets := atomic.LoadInt32(&peer.gets)
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "peer-Tom" || atomic.LoadInt32(&peer.gets) != gets {
+		t.Fatalf("the refreshed value should be cached, got %s %v", v.String(), err)
+	}
+}
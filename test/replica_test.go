@@ -0,0 +1,148 @@
+package cache_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+)
+
+func TestReplicaFailover(t *testing.T) {
+	var local int
+	gee := cache.NewGroup("replica-failover", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			local++
+			return []byte("db-" + key), nil
+		}), cache.WithReplicas(3))
+	dead, b := &fakePeer{err: errors.New("connection refused")}, &fakePeer{prefix: "replica-"}
+	gee.RegisterPeers(replicaPicker{dead, nil, b})
+
+	// 第一个副本故障, 由下一个远程副本返回, 不访问数据源
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "replica-Tom" {
+		t.Fatalf("should fail over to the next replica: %q, %v", v.String(), err)
+	}
+	if local != 0 || dead.gets != 1 || b.gets != 1 || gee.Stats.PeerErrors.Get() != 1 {
+		t.Fatalf("unexpected loads: local %d, dead %d, b %d", local, dead.gets, b.gets)
+	}
+
+	// 所有远程副本都故障时回退到本地
+	b.setErr(errors.New("connection refused"))
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "db-Jack" || local != 1 {
+		t.Fatalf("should fall back to local: %q, %v", v.String(), err)
+	}
+}
+
+func TestReplicaFill(t *testing.T) {
+	gee := cache.NewGroup("replica-fill", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithReplicas(3))
+	a, b, c := &fakePeer{}, &fakePeer{}, &fakePeer{}
+	gee.RegisterPeers(replicaPicker{nil, a, b, c})
+
+	// 本节点是第一个副本, 从数据源载入后写入其他副本, 不会写入副本之外的节点
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("the primary should load locally: %q, %v", v.String(), err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for _, peer := range []*fakePeer{a, b} {
+		for {
+			if v, ok := peer.value("Tom"); ok {
+				if v != "db-Tom" {
+					t.Fatalf("unexpected replica value %q", v)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("replicas should be filled")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if _, ok := c.value("Tom"); ok || a.gets != 0 {
+		t.Fatalf("only the replicas should be filled")
+	}
+}
+
+func TestReplicaSetRemove(t *testing.T) {
+	gee := cache.NewGroup("replica-set", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithReplicas(2))
+	a, b := &fakePeer{}, &fakePeer{}
+	gee.RegisterPeers(replicaPicker{a, b})
+
+	// 写入和删除发送给所有副本
+	if err := gee.Set("Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range []*fakePeer{a, b} {
+		if v, _ := peer.value("Tom"); v != "630" {
+			t.Fatalf("set should go to every replica, got %q", v)
+		}
+	}
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range []*fakePeer{a, b} {
+		if _, ok := peer.value("Tom"); ok {
+			t.Fatalf("remove should go to every replica")
+		}
+	}
+
+	// 一个副本写入失败时返回错误, 其他副本仍然写入
+	a.setErr(errors.New("connection refused"))
+	if err := gee.Set("Jack", []byte("589")); err == nil {
+		t.Fatalf("a failed replica should be reported")
+	}
+	if v, _ := b.value("Jack"); v != "589" {
+		t.Fatalf("other replicas should still be written")
+	}
+
+	// 返回所有副本的错误
+	errA, errB := errors.New("a is down"), errors.New("b is down")
+	a.setErr(errA)
+	b.setErr(errB)
+	if err := gee.Remove("Jack"); !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Fatalf("every replica error should be returned, got %v", err)
+	}
+}
+
+func TestReplicaSetNoReplicas(t *testing.T) {
+	gee := cache.NewGroup("replica-none", 2<<10, cache.GettrFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}), cache.WithReplicas(2))
+	gee.RegisterPeers(replicaPicker{})
+
+	// 没有副本可以写入时返回错误, 不会当作写入成功
+	if err := gee.Set("Tom", []byte("630")); err == nil {
+		t.Fatalf("set without replicas should fail")
+	}
+	if err := gee.Remove("Tom"); err == nil {
+		t.Fatalf("remove without replicas should fail")
+	}
+}
+
+func TestHttpPickReplicas(t *testing.T) {
+	p := cache.NewHttpServer("http://self")
+	p.Set("http://self", "http://a", "http://b")
+	for _, key := range []string{"Tom", "Jack", "Sam"} {
+		replicas := p.PickReplicas(key, 3)
+		self := 0
+		for _, peer := range replicas {
+			if peer == nil {
+				self++
+			}
+		}
+		if len(replicas) != 3 || self != 1 {
+			t.Fatalf("key %s: expect 3 replicas including self, got %v", key, replicas)
+		}
+		// 第一个副本与 PickPeer 相同
+		peer, ok := p.PickPeer(key)
+		if ok != (replicas[0] != nil) || (ok && peer != replicas[0]) {
+			t.Fatalf("key %s: the first replica should be the owner", key)
+		}
+	}
+}
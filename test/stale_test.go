@@ -0,0 +1,106 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+)
+
+// down 为1时数据源不可用
+func flakyGettr(down *int32) cache.GettrFunc {
+	return func(key string) ([]byte, error) {
+		if atomic.LoadInt32(down) == 1 {
+			return nil, errors.New("db down")
+		}
+		if key == "unknown" {
+			return nil, cache.ErrNotFound
+		}
+		return []byte("v-" + key), nil
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var down int32
+	gee := cache.NewGroup("stale-if-error", 8, flakyGettr(&down),
+		cache.WithDefaultTTL(10*time.Millisecond), cache.WithStaleIfError(1<<10, time.Minute))
+
+	gee.Get("a")
+	gee.Get("bb")
+	gee.Get("unknown")
+	gee.Get("ccc") // 容量只有8字节, 淘汰a和bb
+	if err := gee.Remove("ccc"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	// 被淘汰和过期的数据在数据源不可用时返回
+	for _, key := range []string{"a", "bb"} {
+		v, err := gee.Get(key)
+		if err != nil || v.String() != "v-"+key || !v.Stale {
+			t.Fatalf("expect stale v-%s, got %q %v", key, v.String(), err)
+		}
+	}
+	if gee.Stats.StaleIfErrorHits.Get() != 2 {
+		t.Fatalf("expect 2 stale hits, got %v", &gee.Stats.StaleIfErrorHits)
+	}
+	// 主动删除的数据和没有缓存过的数据不会返回
+	for _, key := range []string{"ccc", "dddd"} {
+		if _, err := gee.Get(key); err == nil {
+			t.Fatalf("%s should not be served stale", key)
+		}
+	}
+
+	// 数据源恢复之后返回新值
+	atomic.StoreInt32(&down, 0)
+	if v, err := gee.Get("a"); err != nil || v.Stale {
+		t.Fatalf("expect a fresh value, got %v", err)
+	}
+}
+
+func TestStaleIfErrorDisabled(t *testing.T) {
+	var down int32
+	gee := cache.NewGroup("stale-if-error-disabled", 2<<10, flakyGettr(&down), cache.WithDefaultTTL(10*time.Millisecond))
+	gee.Get("a")
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := gee.Get("a"); err == nil {
+		t.Fatalf("stale values should only be served with WithStaleIfError")
+	}
+}
+
+func TestStaleOverTheWire(t *testing.T) {
+	var down int32
+	gee := cache.NewGroup("stale-wire", 2<<10, flakyGettr(&down),
+		cache.WithDefaultTTL(10*time.Millisecond), cache.WithStaleIfError(1<<10, time.Minute))
+	ts := httptest.NewServer(cache.NewHttpServer("http://owner"))
+	defer ts.Close()
+	client := cache.NewHttpServer("http://self")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("a")
+
+	gee.Get("a")
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "stale-wire", Key: "a"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.GetStale() || string(res.GetValue()) != "v-a" {
+		t.Fatalf("stale flag should be sent to the requester, got %v", res)
+	}
+	batch := &pb.BatchResponse{}
+	if err := peer.GetMany(context.Background(), &pb.BatchRequest{Group: "stale-wire", Keys: []string{"a"}}, batch); err != nil {
+		t.Fatal(err)
+	}
+	if e := batch.GetEntries(); len(e) != 1 || !e[0].GetStale() {
+		t.Fatalf("stale flag should be set in batch entries, got %v", e)
+	}
+}
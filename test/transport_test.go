@@ -0,0 +1,96 @@
+package cache_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "mini-cache"
+	pb "mini-cache/proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// 启动一个节点, handler 返回的状态码不为0时直接返回, 否则返回 value
+func startPeer(t *testing.T, handler func(attempt int32) (int, []byte), opts ...cache.HttpServerOption) (cache.PeerServer, *int32) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, value := handler(atomic.AddInt32(&attempts, 1))
+		if code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: value})
+		w.Write(body)
+	}))
+	t.Cleanup(ts.Close)
+	p := cache.NewHttpServer("http://self", opts...)
+	p.Set(ts.URL)
+	peer, _ := p.PickPeer("key")
+	return peer, &attempts
+}
+
+func TestHttpRetry(t *testing.T) {
+	// 第一次返回503, 重试之后成功
+	unavailableOnce := func(attempt int32) (int, []byte) {
+		if attempt == 1 {
+			return http.StatusServiceUnavailable, nil
+		}
+		return 0, []byte("v")
+	}
+	peer, attempts := startPeer(t, unavailableOnce, cache.WithRetries(2, time.Millisecond))
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "key"}, res); err != nil || string(res.GetValue()) != "v" {
+		t.Fatalf("get should succeed after a retry: %v", err)
+	}
+	if atomic.LoadInt32(attempts) != 2 {
+		t.Fatalf("expect 2 attempts, got %d", atomic.LoadInt32(attempts))
+	}
+
+	// 关闭重试
+	peer, attempts = startPeer(t, unavailableOnce, cache.WithRetries(0, 0))
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "key"}, &pb.Response{}); err == nil || atomic.LoadInt32(attempts) != 1 {
+		t.Fatalf("should fail without retries, attempts %d", atomic.LoadInt32(attempts))
+	}
+
+	// 其他错误不重试
+	peer, attempts = startPeer(t, func(int32) (int, []byte) { return http.StatusInternalServerError, nil },
+		cache.WithRetries(3, time.Millisecond))
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "key"}, &pb.Response{}); err == nil || atomic.LoadInt32(attempts) != 1 {
+		t.Fatalf("500 should not be retried, attempts %d", atomic.LoadInt32(attempts))
+	}
+}
+
+func TestHttpReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	peer, attempts := startPeer(t, func(int32) (int, []byte) {
+		<-release
+		return 0, []byte("v")
+	}, cache.WithTimeouts(0, 20*time.Millisecond, 0), cache.WithRetries(3, time.Millisecond))
+
+	// 卡住的节点在超时之后返回错误, 超时不重试
+	start := time.Now()
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "key"}, &pb.Response{}); err == nil {
+		t.Fatalf("get from a hung peer should time out")
+	}
+	if time.Since(start) > time.Second || atomic.LoadInt32(attempts) != 1 {
+		t.Fatalf("timeout should not be retried, attempts %d", atomic.LoadInt32(attempts))
+	}
+}
+
+func TestHttpMaxResponseBytes(t *testing.T) {
+	large := func(int32) (int, []byte) { return 0, make([]byte, 100) }
+	peer, _ := startPeer(t, large, cache.WithMaxResponseBytes(64))
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "key"}, &pb.Response{}); err == nil {
+		t.Fatalf("response larger than the limit should fail")
+	}
+	peer, _ = startPeer(t, large, cache.WithMaxResponseBytes(1024))
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: "key"}, res); err != nil || len(res.GetValue()) != 100 {
+		t.Fatalf("response within the limit should succeed: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 访问其他节点的HTTP客户端的配置, 每个 HttpServer 一份, 每个节点使用单独的连接池
+
+const (
+	defaultDialTimeout         = 2 * time.Second
+	defaultReadTimeout         = 5 * time.Second
+	defaultRequestTimeout      = 10 * time.Second
+	defaultMaxIdleConnsPerPeer = 64
+	defaultRetries             = 1
+	defaultRetryBackoff        = 10 * time.Millisecond
+	defaultMaxResponseBytes    = 64 << 20
+)
+
+// 响应体超过了 maxResponseBytes
+var errResponseTooLarge = errors.New("response body too large")
+
+type transportConfig struct {
+	// 建立连接的超时时间
+	dialTimeout time.Duration
+	// 发送请求之后等待响应头的超时时间
+	readTimeout time.Duration
+	// 整个请求的超时时间, 包括读取响应体
+	requestTimeout time.Duration
+	// 每个节点保留的空闲连接数量
+	maxIdleConnsPerPeer int
+	// 临时错误的最大重试次数, 以及第一次重试前等待的时间, 之后每次翻倍
+	retries      int
+	retryBackoff time.Duration
+	// 响应体的最大长度
+	maxResponseBytes int64
+}
+
+func defaultTransportConfig() transportConfig {
+	return transportConfig{
+		dialTimeout:         defaultDialTimeout,
+		readTimeout:         defaultReadTimeout,
+		requestTimeout:      defaultRequestTimeout,
+		maxIdleConnsPerPeer: defaultMaxIdleConnsPerPeer,
+		retries:             defaultRetries,
+		retryBackoff:        defaultRetryBackoff,
+		maxResponseBytes:    defaultMaxResponseBytes,
+	}
+}
+
+// 为一个节点创建HTTP客户端
+func (c transportConfig) newClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   c.dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = c.readTimeout
+	transport.MaxIdleConns = c.maxIdleConnsPerPeer
+	transport.MaxIdleConnsPerHost = c.maxIdleConnsPerPeer
+	return &http.Client{
+		Transport: transport,
+		Timeout:   c.requestTimeout,
+	}
+}
+
+// 第attempt次重试前等待的时间: 指数退避, 在 [d/2, d] 之间随机, 避免所有请求同时重试
+func (c transportConfig) backoff(attempt int) time.Duration {
+	d := c.retryBackoff << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// 在重试之前等待, ctx 被取消时返回false
+func (c transportConfig) wait(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(c.backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 请求没有到达对方或者对方暂时不可用, 可以重试
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// 连接失败可以重试; 超时和取消不重试, 否则一个卡住的节点会让等待的时间成倍增加
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return true
+}
+
+// WithTimeouts 设置访问其他节点的超时时间: 建立连接, 等待响应头和整个请求。
+// 为0的值不修改, 默认分别为 2s, 5s, 10s。
+func WithTimeouts(dial, read, request time.Duration) HttpServerOption {
+	return func(p *HttpServer) {
+		if dial > 0 {
+			p.transport.dialTimeout = dial
+		}
+		if read > 0 {
+			p.transport.readTimeout = read
+		}
+		if request > 0 {
+			p.transport.requestTimeout = request
+		}
+	}
+}
+
+// WithMaxIdleConnsPerPeer 设置每个节点保留的空闲连接数量, 默认为64
+func WithMaxIdleConnsPerPeer(n int) HttpServerOption {
+	return func(p *HttpServer) {
+		if n > 0 {
+			p.transport.maxIdleConnsPerPeer = n
+		}
+	}
+}
+
+// WithRetries 设置连接失败或者对方返回429/503时的最大重试次数, 每次重试前等待 backoff 的指数倍加上随机抖动。
+// 超时不会重试。默认重试1次, backoff 为10ms; retries 为0时不重试。
+func WithRetries(retries int, backoff time.Duration) HttpServerOption {
+	return func(p *HttpServer) {
+		if retries >= 0 {
+			p.transport.retries = retries
+		}
+		if backoff > 0 {
+			p.transport.retryBackoff = backoff
+		}
+	}
+}
+
+// WithMaxResponseBytes 设置其他节点的响应体的最大长度, 超过时请求失败。默认为64MB
+func WithMaxResponseBytes(n int64) HttpServerOption {
+	return func(p *HttpServer) {
+		if n > 0 {
+			p.transport.maxResponseBytes = n
+		}
+	}
+}
@@ -5,6 +5,8 @@ package view
 // ByteView 用来表示缓存值，只读
 type ByteView struct {
 	B []byte
+	// 数据源和负责的节点都不可用时返回的旧值, 可能已经过期或被淘汰
+	Stale bool
 }
 
 // 返回长度, 实现了lru中的 value 接口